@@ -1,19 +1,27 @@
 package cmd
 
 import (
-	"bufio"
 	"database/sql"
+	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
-
+	"time"
+	"unicode/utf8"
+
+	"github.com/darkmattermatt/dumpdb/internal/checkpoint"
+	"github.com/darkmattermatt/dumpdb/internal/config"
+	"github.com/darkmattermatt/dumpdb/internal/engine"
+	"github.com/darkmattermatt/dumpdb/internal/errlog"
+	"github.com/darkmattermatt/dumpdb/internal/linescanner"
 	"github.com/darkmattermatt/dumpdb/internal/parseline"
 	"github.com/darkmattermatt/dumpdb/internal/sourceid"
+	"github.com/darkmattermatt/dumpdb/pkg/globmatch"
 	"github.com/darkmattermatt/dumpdb/pkg/reverse"
 	l "github.com/darkmattermatt/dumpdb/pkg/simplelog"
+	"github.com/darkmattermatt/dumpdb/pkg/walkfiles"
 	"github.com/pbnjay/memory"
 	"github.com/spf13/cobra"
 )
@@ -25,13 +33,6 @@ func showUsage(cmd *cobra.Command, s string) {
 	l.F(s)
 }
 
-func getDataDir() (dataDir string) {
-	err := db.QueryRow("SELECT @@datadir").Scan(&dataDir)
-	l.FatalOnErr("Querying location of MySQL databases", err)
-	l.D("dataDir: " + dataDir)
-	return
-}
-
 func formatCommandOutput(s string) string {
 	s = strings.ReplaceAll(s, "\r\n", "\n")
 	s = strings.ReplaceAll(s, "\n\n", "\n")
@@ -43,84 +44,108 @@ func formatCommandOutput(s string) string {
 func queryDatabaseEngine() string {
 	l.I("Querying database engine type")
 
-	var engine string
+	var name string
 	err := db.QueryRow(`
 		SELECT engine
 		FROM information_schema.tables
 		WHERE table_name='` + mainTable + `' AND table_schema='` + c.Database + `'
-	`).Scan(&engine)
+	`).Scan(&name)
 	l.FatalOnErr("Querying database engine type", err)
 
-	l.V("Found database engine: " + engine)
-	return strings.ToLower(engine)
+	l.V("Found database engine: " + name)
+	return strings.ToLower(name)
 }
 
-func disableDatabaseIndexes(dataDir string) {
-	l.I("Disabling database indexes")
-
-	packCmd := "aria_chk"
-	if c.Engine == "myisam" {
-		packCmd = "myisamchk"
+// openDatabaseEngine creates the engine for the storage engine of the main table, and checks that it can be used
+func openDatabaseEngine() {
+	l.FatalOnErr("Setting engine", c.SetEngine(queryDatabaseEngine()))
+
+	// TODO: customisable tmpDir
+	var err error
+	dbEngine, err = engine.New(c.Engine, engine.Options{
+		DB:          db,
+		Database:    c.Database,
+		Table:       mainTable,
+		Remote:      c.Remote,
+		Compression: c.Compress,
+		TmpDir:      os.TempDir(),
+		SortBuffer:  sortBufferSize(),
+
+		InsertRows:        c.InsertBatchSize,
+		InsertConcurrency: c.InsertConcurrency,
+	})
+	l.FatalOnErr("Opening database engine", err)
+	engine.CommandCallback = func(name, output string) {
+		l.D(formatCommandOutput(output))
 	}
-
-	out, err := exec.Command(packCmd, "-rq", "--keys-used", "0", dataDir+c.Database+"/"+mainTable).CombinedOutput()
-	l.D(formatCommandOutput(string(out)))
-	l.FatalOnErr("Disabling database indexes", err)
+	l.FatalOnErr("Checking the database engine", dbEngine.Check())
 }
 
-func restoreDatabaseIndexes(dataDir, tmpDir string) {
-	l.I("Indexing database")
-
+// sortBufferSize is the amount of memory to use for sorting while rebuilding indexes
+func sortBufferSize() uint64 {
 	mem := memory.TotalMemory()
 	if mem != 0 {
 		// TODO: Add configurable percentage
 		l.V("Detected RAM: " + strconv.FormatUint(mem/1024/1024/1000, 10) + "GB. Using 25% as the sort buffer.")
-		mem /= 4
-	} else {
-		l.V("Failed to detect the amount system RAM. Using 512MB as the sort buffer.")
-		mem = 512 * 1024 * 1024
+		return mem / 4
 	}
+	l.V("Failed to detect the amount system RAM. Using 512MB as the sort buffer.")
+	return 512 * 1024 * 1024
+}
 
-	packCmd := "aria_chk"
-	bufferParam := "--sort_buffer_size"
-	if c.Engine == "myisam" {
-		packCmd = "myisamchk"
-		bufferParam = "--myisam_sort_buffer_size"
+func disableDatabaseIndexes() {
+	l.I("Disabling database indexes")
+	l.FatalOnErr("Disabling database indexes", dbEngine.DisableIndexes())
+	if note := dbEngine.RecoveryNote(); note != "" {
+		prependRecoveryNote(note)
 	}
+}
 
-	out, err := exec.Command(packCmd, "-rq", bufferParam, strconv.FormatUint(mem, 10), "--tmpdir", tmpDir, dataDir+c.Database+"/"+mainTable).CombinedOutput()
-	l.D(formatCommandOutput(string(out)))
-	l.FatalOnErr("Indexing database", err)
+func restoreDatabaseIndexes() {
+	l.I("Indexing database")
+	l.FatalOnErr("Indexing database", dbEngine.RebuildIndexes())
+	setRecoveryNote("")
 }
 
-func compressDatabase(dataDir, tmpDir string) {
+func compressDatabase() {
 	l.I("Compressing database")
+	l.FatalOnErr("Compressing database", dbEngine.Compress())
+}
 
-	packCmd := "aria_pack"
-	if c.Engine == "myisam" {
-		packCmd = "myisampack"
-	}
+// checkpointFileName is the file, after the file prefix, that stores the checkpoint of an import in progress
+const checkpointFileName = "checkpoint.log"
 
-	out, err := exec.Command(packCmd, "--tmpdir", tmpDir, dataDir+c.Database+"/"+mainTable).CombinedOutput()
-	l.D(formatCommandOutput(string(out)))
-	l.FatalOnErr("Compressing database", err)
+// batchProgress is the input position reached by the end of a tmp batch
+type batchProgress struct {
+	checkpoint checkpoint.Checkpoint
+	done       []string
 }
 
-func importToDatabase(filename string, mysqlDone chan bool) {
+// finishBatch takes a snapshot of the progress at the end of the tmp batch numbered `batch`
+func finishBatch(batch int) batchProgress {
+	p := batchProgress{checkpoint: lastWritten, done: pendingDone}
+	p.checkpoint.Batch = batch
+	pendingDone = nil
+	return p
+}
+
+// saveProgress records the files that are complete and the checkpoint, after a batch has been loaded into the database
+func saveProgress(p batchProgress) {
+	for _, path := range p.done {
+		_, err := doneFile.WriteString(path + "\n")
+		l.FatalOnErr("Writing to done log", err)
+	}
+	err := p.checkpoint.Save(c.FilePrefix + checkpointFileName)
+	l.FatalOnErr("Saving checkpoint", err)
+}
+
+func importToDatabase(filename string, records int, progress batchProgress, mysqlDone chan bool) {
 	filename, err := filepath.Abs(filename)
 	l.FatalOnErr("Determining the absolute filepath of "+filename, err)
 
 	l.I("Importing " + filename + " to the database")
-	filename = strings.ReplaceAll(filename, "\\", "\\\\")
-
-	_, err = db.Exec(`
-		LOAD DATA INFILE '` + filename + `'
-		IGNORE INTO TABLE ` + mainTable + `
-		FIELDS TERMINATED BY '\t' ESCAPED BY ''
-		LINES TERMINATED BY '\n'
-		(sourceid, username, email_rev, hash, password, extra)
-	`)
-	l.FatalOnErr("Loading tmp file into database", err)
+	loadBatchFile(filename, int64(records))
+	saveProgress(progress)
 	mysqlDone <- true
 
 	// delete file we just loaded
@@ -128,55 +153,328 @@ func importToDatabase(filename string, mysqlDone chan bool) {
 	l.WarnOnErr("Removing tmp file "+filename, err)
 }
 
+// loadBatchFile loads a tmp file with LOAD DATA INFILE, or with INSERT statements if the server doesn't allow LOAD DATA
+func loadBatchFile(filename string, records int64) {
+	start := time.Now()
+	if !useInsert {
+		err := dbEngine.Load(filename)
+		if err == nil {
+			recordLoad(loadInfile, records, time.Since(start))
+			return
+		}
+		if !engine.IsLoadDataDenied(err) {
+			l.FatalOnErr("Loading tmp file into database", err)
+		}
+		l.W("The database server doesn't allow LOAD DATA INFILE (" + err.Error() + "). Loading with INSERT statements instead, which is slower")
+		useInsert = true
+		start = time.Now()
+	}
+
+	f, err := os.Open(filename)
+	l.FatalOnErr("Opening tmp file "+filename, err)
+	defer f.Close()
+	l.FatalOnErr("Inserting tmp file into database", dbEngine.Insert(f))
+	recordLoad(loadInsert, records, time.Since(start))
+}
+
 func waitForImport(mysqlDone chan bool) {
 	l.D("Waiting for a database load to finish")
 	<-mysqlDone
 }
 
-func flushAndLockTables() {
-	l.V("Flushing and locking the `" + mainTable + "` table")
-	_, err := db.Exec(`
-		FLUSH TABLES ` + mainTable + `
-		FOR EXPORT
-	`)
-	l.FatalOnErr("Flushing and locking the `"+mainTable+"` table", err)
+// logSkipped records a file that was not processed in the skip log
+func logSkipped(path, reason string) {
+	if skipFile == nil {
+		// `parser test` doesn't write any logs
+		l.W("Skipping (" + reason + "): " + path)
+		return
+	}
+	l.V("Skipping (" + reason + "): " + path)
+	_, err := skipFile.WriteString(path + "\t" + reason + "\n")
+	l.FatalOnErr("Writing to skip log", err)
+}
+
+// reportRepairedLines logs the total number of lines that contained invalid UTF-8
+func reportRepairedLines() {
+	if repairedLines > 0 {
+		l.I("Repaired " + strconv.FormatInt(repairedLines, 10) + " lines with invalid UTF-8")
+	}
+}
+
+// configureLineScanner applies the config to the linescanner package
+func configureLineScanner() {
+	linescanner.SkipCallback = logSkipped
+	linescanner.MaxDepth = c.MaxDepth
+	linescanner.MaxSize = c.MaxSize
+	linescanner.Encoding = c.Encoding
+	linescanner.MaxLineLength = c.MaxLineLength
+	if quarantineFile != nil {
+		linescanner.Quarantine = quarantineFile
+	}
+	linescanner.Sniff = c.Sniff
+	linescanner.AllowExtensions = c.AllowExtensions
+	linescanner.DenyExtensions = c.DenyExtensions
+	linescanner.EncodingCallback = func(path, encoding string) {
+		l.D("Encoding of " + path + ": " + encoding)
+	}
+}
+
+// processFilesOrFolders walks each of the configured files or folders, calling `callback` for every text file found
+func processFilesOrFolders(callback func(string, *linescanner.Scanner) error) error {
+	walker := walkfiles.Walker{
+		Include:        c.Include,
+		Exclude:        c.Exclude,
+		FollowSymlinks: c.FollowSymlinks,
+		// every file that is found is written to either the done log or the skip log
+		SkipCallback: logSkipped,
+	}
+
+	configureLineScanner()
+
+	for _, fileOrFolder := range c.FilesOrFolders {
+		walk := walker.Walk
+		if fileOrFolder == config.StdinPath {
+			walk = func(path string, callback func(string) error) error {
+				return callback(path)
+			}
+		}
+
+		err := walk(fileOrFolder, func(path string) error {
+			err := processFile(path, callback)
+			if err != nil && err != errSignalInterrupt {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processFile scans a single file, which may be standard input or a named pipe
+func processFile(path string, callback func(string, *linescanner.Scanner) error) error {
+	if path == config.StdinPath {
+		l.V("Reading from standard input")
+		return linescanner.ReaderLineScanner("stdin", os.Stdin, callback)
+	}
+
+	return linescanner.LineScanner(path, callback)
+}
+
+// lineParserFor returns the line parser of the first parser mapping that matches the path, or the default line parser
+func lineParserFor(path string) string {
+	name := filepath.ToSlash(filepath.Clean(path))
+	for _, m := range c.ParserMap {
+		if globmatch.MatchBase(m.Glob, name) {
+			return m.Parser
+		}
+	}
+	return c.LineParser
+}
+
+// lineReader is the part of a linescanner.Scanner that is used to process a file, so that lines can also be replayed from a log
+type lineReader interface {
+	Scan() bool
+	Text() string
+	Line() int64
+	Offset() int64
+	Err() error
+	Oversized() int64
+	Archive() string
+	Member() string
+}
+
+// sampledLine is a line that was read ahead to detect the line parser
+type sampledLine struct {
+	text   string
+	line   int64
+	offset int64
+}
+
+// sampledReader replays the sampled lines of a file, then continues with the rest of the file
+type sampledReader struct {
+	lineReader
+	sample    []sampledLine
+	cur       sampledLine
+	replaying bool
+}
+
+func (r *sampledReader) Scan() bool {
+	if len(r.sample) > 0 {
+		r.cur, r.sample, r.replaying = r.sample[0], r.sample[1:], true
+		return true
+	}
+	r.replaying = false
+	return r.lineReader.Scan()
+}
+
+func (r *sampledReader) Text() string {
+	if r.replaying {
+		return r.cur.text
+	}
+	return r.lineReader.Text()
+}
+
+func (r *sampledReader) Line() int64 {
+	if r.replaying {
+		return r.cur.line
+	}
+	return r.lineReader.Line()
+}
+
+func (r *sampledReader) Offset() int64 {
+	if r.replaying {
+		return r.cur.offset
+	}
+	return r.lineReader.Offset()
+}
+
+// sampleLineScanner reads up to n non-blank lines. The returned lineReader reads them again before the rest of the file
+func sampleLineScanner(lineScanner lineReader, n int) (lineReader, []string, error) {
+	r := &sampledReader{lineReader: lineScanner}
+	var sample []string
+	for len(sample) < n && lineScanner.Scan() {
+		if line := lineScanner.Text(); line != "" {
+			sample = append(sample, line)
+			r.sample = append(r.sample, sampledLine{line, lineScanner.Line(), lineScanner.Offset()})
+		}
+	}
+	return r, sample, lineScanner.Err()
+}
+
+// maxTrackedOffsets is the number of lines whose offsets are remembered while waiting for their results
+const maxTrackedOffsets = 1 << 16
+
+// lineOffsets remembers the byte offsets of the lines that may still have results from the line parser. Line parsers
+// emit on the goroutine that calls Parse and Flush, so the scan loop and emit share it without a lock
+type lineOffsets struct {
+	queue [][2]int64
 }
 
-func unlockTables() {
-	l.V("Unlocking the `" + mainTable + "` table")
-	_, err := db.Exec(`
-		UNLOCK TABLES
-	`)
-	l.FatalOnErr("Unlocking the `"+mainTable+"` table", err)
+func (o *lineOffsets) add(line, offset int64) {
+	if len(o.queue) >= maxTrackedOffsets {
+		o.queue = o.queue[1:]
+	}
+	o.queue = append(o.queue, [2]int64{line, offset})
 }
 
-func processTextFileScanner(path string, lineScanner *bufio.Scanner, toImport bool) error {
-	if !strings.HasSuffix(path, ".txt") && !strings.HasSuffix(path, ".csv") {
-		l.V("Skipping: " + path)
-		_, err := skipFile.WriteString(path + "\n")
-		l.FatalOnErr("Writing to skip log", err)
+// get returns the offset of a line, or -1 if it is unknown. Results arrive in order, so earlier lines are forgotten
+func (o *lineOffsets) get(line int64) int64 {
+	for len(o.queue) > 0 && o.queue[0][0] < line {
+		o.queue = o.queue[1:]
+	}
+	if len(o.queue) > 0 && o.queue[0][0] == line {
+		return o.queue[0][1]
+	}
+	return -1
+}
+
+// detectLineParser picks the best line parser for the sampled lines. It returns false if the file should be skipped
+func detectLineParser(path string, sample []string) (string, bool) {
+	if len(sample) == 0 {
+		return "", true
+	}
+
+	parser, score := parseline.Detect(sample)
+	scoreStr := strconv.FormatFloat(score, 'f', 2, 64)
+	if parser == "" {
+		logSkipped(path, "no line parser could parse the sampled lines")
+		return "", false
+	}
+	if score < c.AutoThreshold {
+		logSkipped(path, "no line parser scored above "+strconv.FormatFloat(c.AutoThreshold, 'f', 2, 64)+" (best was "+parser+" with "+scoreStr+")")
+		return "", false
+	}
+	l.I("Detected line parser " + parser + " for " + path + " (score " + scoreStr + ")")
+	return parser, true
+}
+
+// sourceNamer returns a function that names the source of each record of a file, from the source name template
+func sourceNamer(path string, lineScanner lineReader, parser string) func(source string) string {
+	vars := sourceid.Vars{Path: path, Archive: lineScanner.Archive(), Member: lineScanner.Member(), Database: c.Database, Parser: parser}
+	if !c.SourceName.UsesSource() {
+		// the name only depends on the file
+		name := c.SourceName.Render(vars)
+		return func(string) string {
+			return name
+		}
+	}
+
+	// most records of a file have the same source, so remember the last one
+	lastSource, lastName := "", ""
+	return func(source string) string {
+		if source != lastSource || lastName == "" {
+			vars.Source = source
+			lastSource, lastName = source, c.SourceName.Render(vars)
+		}
+		return lastName
+	}
+}
+
+func processTextFileScanner(path string, lineScanner lineReader, toImport bool) error {
+	if doneFiles[path] {
+		l.V("Already imported: " + path)
 		return nil
 	}
 
-	l.V("Processing: " + path)
+	var resumeLine, resumeRecords int64
+	if resumeFrom.Source == path {
+		resumeLine, resumeRecords = resumeFrom.Line, resumeFrom.Records
+		l.V("Resuming: " + path + " from line " + strconv.FormatInt(resumeLine, 10))
+	} else {
+		l.V("Processing: " + path)
+	}
 
-	for lineScanner.Scan() {
-		// CTRL+C means stop
-		if signalInterrupt {
-			return errSignalInterrupt
+	parser := lineParserFor(path)
+	if parser == parseline.Auto {
+		// read the first lines of the file to detect the line parser, they are parsed again below
+		var sample []string
+		var err error
+		lineScanner, sample, err = sampleLineScanner(lineScanner, c.AutoSample)
+		if err != nil {
+			return err
 		}
 
-		line := lineScanner.Text()
-		// skip blank lines
-		if line == "" {
-			continue
+		var ok bool
+		parser, ok = detectLineParser(path, sample)
+		if !ok {
+			return nil
 		}
+	}
 
-		// parse & reformat line
-		r, err := parseline.ParseLine(c.LineParser, line, path)
+	lineParser, err := parseline.Stream(parser)
+	if err != nil {
+		return err
+	}
+	// parsers that read several lines at a time are given the lines before the checkpoint too, so that they see the
+	// header or statement that the remaining records belong to
+	skipLoadedLines := parseline.IsLineParser(parser) || parseline.IsExecParser(parser)
+
+	sourceName := sourceNamer(path, lineScanner, parser)
+	var offsets lineOffsets
+
+	// write each parsed record to the output file, the results of a line parser may arrive after later lines are read
+	emit := func(line string, lineNum int64, r parseline.Record, err error) {
+		// records with tabs or newlines would shift the columns of the batch file, so they go to the error log
+		if err == nil {
+			err = r.Check()
+		}
+
+		// skip records that were loaded before the import was interrupted
+		if lineNum < resumeLine || (lineNum == resumeLine && (err != nil || resumeRecords > 0)) {
+			if err == nil {
+				resumeRecords--
+			}
+			return
+		}
+
+		offset := offsets.get(lineNum)
 		if err != nil {
-			errFile.WriteString(line + "\n")
-			continue
+			entry := errlog.Entry{Source: path, Line: lineNum, Offset: offset, Parser: parser, Error: err.Error(), Text: line}
+			l.FatalOnErr("Writing to error log", errlog.Write(errFile, entry))
+			return
 		}
 
 		if r.EmailRev == "" && r.Email != "" {
@@ -185,6 +483,8 @@ func processTextFileScanner(path string, lineScanner *bufio.Scanner, toImport bo
 			r.Email = reverse.Reverse(r.EmailRev)
 		}
 
+		r.Source = sourceName(r.Source)
+
 		var arr []string
 		if toImport {
 			r.SourceID, err = sourceid.SourceID(r.Source, sourcesDb, sourcesTable)
@@ -197,8 +497,67 @@ func processTextFileScanner(path string, lineScanner *bufio.Scanner, toImport bo
 		// write string to output file
 		_, err = outputFile.WriteString(strings.Join(arr, "\t") + "\n")
 		l.FatalOnErr("Writing processed string to output file", err)
+		if lastWritten.Source == path && lastWritten.Line == lineNum {
+			lastWritten.Records++
+		} else {
+			lastWritten.Source = path
+			lastWritten.Line = lineNum
+			lastWritten.Records = 1
+		}
+	}
+
+	var repaired int64
+	for lineScanner.Scan() {
+		line, lineNum := lineScanner.Text(), lineScanner.Line()
+
+		// CTRL+C means stop, after writing the lines that have already been parsed
+		if signalCaught() {
+			l.WarnOnErr("Finishing line parser", lineParser.Flush())
+			return errSignalInterrupt
+		}
+
+		// skip lines that were loaded before the import was interrupted
+		if skipLoadedLines && lineNum <= resumeLine {
+			continue
+		}
+
+		// skip blank lines
+		if line == "" {
+			continue
+		}
+
+		// replace invalid UTF-8 sequences, the database expects utf8mb4
+		if !utf8.ValidString(line) {
+			line = strings.ToValidUTF8(line, "\uFFFD")
+			repaired++
+		}
+
+		// parse & reformat line
+		offsets.add(lineNum, lineScanner.Offset())
+		if err := lineParser.Parse(line, path, lineNum, emit); err != nil {
+			return err
+		}
+	}
+	if err := lineParser.Flush(); err != nil {
+		return err
+	}
+	if err := lineScanner.Err(); err != nil {
+		return err
+	}
+	if n := lineScanner.Oversized(); n > 0 {
+		l.W("Quarantined " + strconv.FormatInt(n, 10) + " lines longer than " + strconv.Itoa(c.MaxLineLength) + " bytes in " + path)
+	}
+	if repaired > 0 {
+		l.V("Repaired " + strconv.FormatInt(repaired, 10) + " lines with invalid UTF-8 in " + path)
+		repairedLines += repaired
+	}
+
+	if toImport {
+		// the file is only done once the batch containing its last line has been loaded
+		pendingDone = append(pendingDone, path)
+	} else {
+		doneFile.WriteString(path + "\n")
 	}
-	doneFile.WriteString(path + "\n")
 	return nil
 }
 
@@ -1,15 +1,22 @@
 package cmd
 
 import (
-	"bufio"
+	"bytes"
 	"database/sql"
+	"io"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/darkmattermatt/dumpdb/internal/checkpoint"
+	"github.com/darkmattermatt/dumpdb/internal/engine"
 	"github.com/darkmattermatt/dumpdb/internal/linescanner"
-
+	"github.com/darkmattermatt/dumpdb/internal/parseline"
 	l "github.com/darkmattermatt/dumpdb/pkg/simplelog"
 	"github.com/darkmattermatt/dumpdb/pkg/splitfilewriter"
+	"github.com/darkmattermatt/dumpdb/pkg/splitstreamwriter"
 	"github.com/spf13/cobra"
 )
 
@@ -27,15 +34,34 @@ var importCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(importCmd)
 
-	// Positional args: filesOrFolders: files and/or folders to import
-	importCmd.Flags().StringP("parser", "p", "", "the custom line parser to use. Modify the internal/parseline package to add another line parser")
+	// Positional args: filesOrFolders: files and/or folders to import. Use - to read from stdin
+	importCmd.Flags().StringP("parser", "p", "", "the line parser to use. Define another line parser under parsers in the config file or in the internal/parseline package. Auto detects the line parser of each file")
+	importCmd.Flags().StringSlice("parserMap", []string{}, "comma separated list of glob=parser, to use a different line parser for matching files, e.g. **/adobe*/*.txt=adobe. The first match wins, otherwise --parser is used")
+	importCmd.Flags().Int("autoSample", 100, "number of lines of each file to sample when the parser is auto")
+	importCmd.Flags().Float64("autoThreshold", 0.5, "minimum score (0-1) of the best line parser when the parser is auto. Files below it are skipped")
+	importCmd.Flags().String("sourceName", "", "template of the source name of each record, with any of {source} {path} {archive} {member} {dirname} {basename} {database} {parser}, e.g. {archive}/{member}. Standard input is named stdin (default \"{source}\", the name from the line parser or the file path)")
+	importCmd.Flags().StringSlice("include", []string{}, "comma separated list of globs that files inside folders must match, e.g. *.txt,**/data/*.csv")
+	importCmd.Flags().StringSlice("exclude", []string{}, "comma separated list of globs of files and folders inside folders to skip")
+	importCmd.Flags().Bool("followSymlinks", false, "follow symbolic links inside folders")
+	importCmd.Flags().Int("maxDepth", 5, "maximum number of nested archives to open. 1 opens archives but not archives inside them")
+	importCmd.Flags().Int64("maxDecompressedSize", 256<<30, "maximum number of bytes to decompress from a single file, to guard against zip bombs. 0 means no limit")
+	importCmd.Flags().String("encoding", "auto", "character encoding of the text files, e.g. utf-8, utf-16le, latin1, cp1251. Auto detects the encoding of each file")
+	importCmd.Flags().Int("maxLineLength", 1024*1024, "maximum number of bytes in a line. Longer lines are skipped and written to the quarantine log")
+	importCmd.Flags().Bool("sniff", true, "skip files that look like binary files from their first few KB")
+	importCmd.Flags().StringSlice("allowExtensions", []string{}, "comma separated list of file extensions to process, e.g. .txt,.csv. Empty allows any extension")
+	importCmd.Flags().StringSlice("denyExtensions", defaultDenyExtensions, "comma separated list of file extensions to skip")
 	importCmd.Flags().StringP("conn", "c", "", "connection string for the SQL database. Like user:pass@tcp(127.0.0.1:3306)")
 	importCmd.Flags().StringP("database", "d", "", "database name to import into")
 	importCmd.Flags().StringP("sourcesDatabase", "s", "", "database name to store sources in")
-	importCmd.Flags().Bool("compress", false, "pack the database into a compressed, read-only format. Requires the Aria or MyISAM database engine")
+	importCmd.Flags().Bool("compress", false, "compress the database after importing. Aria and MyISAM tables are packed into a read-only format, InnoDB tables use page compression")
 
 	importCmd.Flags().Int("batchSize", 4e6, "number of lines per temporary file (used for the LOAD FILE INTO command). 1e6 = ~64MB, 16e6 = ~1GB")
+	importCmd.Flags().Bool("remote", false, "stream each batch to the database server with LOAD DATA LOCAL INFILE instead of writing tmp files, and only change the database through SQL. For database servers on other hosts")
+	importCmd.Flags().String("loader", "auto", "how batches are loaded: auto uses LOAD DATA, and multi-row INSERT statements if the database server doesn't allow LOAD DATA. insert always uses INSERT statements")
+	importCmd.Flags().Int("insertBatchSize", 1000, "number of rows of each INSERT statement, when batches are loaded with INSERT statements")
+	importCmd.Flags().Int("insertConcurrency", 4, "number of connections that send INSERT statements at once")
 	importCmd.Flags().StringP("filePrefix", "o", "[database]_", "temporary processed file prefix")
+	importCmd.Flags().Bool("resume", false, "skip files listed in the done log and continue from the last checkpoint of an interrupted import")
 
 	importCmd.MarkFlagRequired("parser")
 	importCmd.MarkFlagRequired("conn")
@@ -51,56 +77,72 @@ func loadImportConfig(cmd *cobra.Command, filesOrFolders []string) {
 	l.FatalOnErr("Setting compress", c.SetCompress(v.GetBool("compress")))
 	l.FatalOnErr("Setting batch size", c.SetBatchSize(v.GetInt("batchSize")))
 	l.FatalOnErr("Setting compress", c.SetFilePrefix(v.GetString("filePrefix")))
+	l.FatalOnErr("Setting resume", c.SetResume(v.GetBool("resume")))
+	l.FatalOnErr("Setting remote", c.SetRemote(v.GetBool("remote")))
+	l.FatalOnErr("Setting loader", c.SetLoader(v.GetString("loader")))
+	l.FatalOnErr("Setting insert batch size", c.SetInsertBatchSize(v.GetInt("insertBatchSize")))
+	l.FatalOnErr("Setting insert concurrency", c.SetInsertConcurrency(v.GetInt("insertConcurrency")))
 
 	l.FatalOnErr("Setting line parser", c.SetLineParser(v.GetString("parser")))
+	l.FatalOnErr("Setting parser map", c.SetParserMap(v.GetStringSlice("parserMap")))
+	l.FatalOnErr("Setting auto parser sample size", c.SetAutoSample(v.GetInt("autoSample")))
+	l.FatalOnErr("Setting auto parser threshold", c.SetAutoThreshold(v.GetFloat64("autoThreshold")))
+	l.FatalOnErr("Setting include globs", c.SetInclude(v.GetStringSlice("include")))
+	l.FatalOnErr("Setting exclude globs", c.SetExclude(v.GetStringSlice("exclude")))
+	l.FatalOnErr("Setting follow symlinks", c.SetFollowSymlinks(v.GetBool("followSymlinks")))
+	l.FatalOnErr("Setting maximum archive depth", c.SetMaxDepth(v.GetInt("maxDepth")))
+	l.FatalOnErr("Setting maximum decompressed size", c.SetMaxSize(v.GetInt64("maxDecompressedSize")))
+	l.FatalOnErr("Setting encoding", c.SetEncoding(v.GetString("encoding")))
+	l.FatalOnErr("Setting maximum line length", c.SetMaxLineLength(v.GetInt("maxLineLength")))
+	l.FatalOnErr("Setting sniff", c.SetSniff(v.GetBool("sniff")))
+	l.FatalOnErr("Setting allowed extensions", c.SetAllowExtensions(v.GetStringSlice("allowExtensions")))
+	l.FatalOnErr("Setting denied extensions", c.SetDenyExtensions(v.GetStringSlice("denyExtensions")))
+	l.FatalOnErr("Setting source name", c.SetSourceName(v.GetString("sourceName")))
 	l.FatalOnErr("Setting files or folders", c.SetFilesOrFolders(filesOrFolders))
 }
 
-func checkDatabaseToolsExist() {
-	if c.Engine == "aria" {
-		_, err := exec.LookPath("aria_chk")
-		l.FatalOnErr("Checking that the required database tools are in PATH", err)
-
-		if c.Compress {
-			_, err = exec.LookPath("aria_pack")
-			l.FatalOnErr("Checking that the required database tools are in PATH", err)
-		}
-	} else if c.Engine == "myisam" {
-		_, err := exec.LookPath("myisamchk")
-		l.FatalOnErr("Checking that the required database tools are in PATH", err)
-
-		if c.Compress {
-			_, err = exec.LookPath("myisampack")
-			l.FatalOnErr("Checking that the required database tools are in PATH", err)
+// loadCheckpoint loads the done log and checkpoint when resuming, returning the number of the first tmp batch to write
+func loadCheckpoint() int {
+	path := c.FilePrefix + checkpointFileName
+	if !c.Resume {
+		_, exists, _ := checkpoint.Load(path)
+		if exists {
+			l.W("Discarding the checkpoint of an interrupted import. Use --resume to continue the interrupted import instead")
+			l.WarnOnErr("Removing checkpoint file", checkpoint.Remove(path))
 		}
+		return 0
 	}
-}
 
-func checkDatabaseFilePermissions(dataDir string) {
-	fname := dataDir + c.Database + "/" + mainTable
-	if c.Engine == "aria" {
-		f, err := os.OpenFile(fname+".MAD", os.O_RDWR, 0)
-		l.FatalOnErr("Checking read/write permissions", err)
-		f.Close()
-		f, err = os.OpenFile(fname+".MAI", os.O_RDWR, 0)
-		l.FatalOnErr("Checking read/write permissions", err)
-		f.Close()
-	} else if c.Engine == "myisam" {
-		f, err := os.OpenFile(fname+".MYD", os.O_RDWR, 0)
-		l.FatalOnErr("Checking read/write permissions", err)
-		f.Close()
-		f, err = os.OpenFile(fname+".MYI", os.O_RDWR, 0)
-		l.FatalOnErr("Checking read/write permissions", err)
-		f.Close()
+	var err error
+	doneFiles, err = checkpoint.LoadDone(c.FilePrefix + "done.log")
+	l.FatalOnErr("Loading done log", err)
+	l.I("Resuming: skipping", len(doneFiles), "files which are already imported")
+
+	cp, exists, err := checkpoint.Load(path)
+	l.FatalOnErr("Loading checkpoint", err)
+	if !exists {
+		return 0
 	}
+
+	resumeFrom = cp
+	l.I("Resuming: continuing " + cp.Source + " from line " + strconv.FormatInt(cp.Line, 10) + ", after tmp batch " + strconv.Itoa(cp.Batch))
+	return cp.Batch + 1
 }
 
 func runImport(cmd *cobra.Command, filesOrFolders []string) {
 	loadImportConfig(cmd, filesOrFolders)
+	setRecoveryNote("To continue the import, rerun the same command with --resume")
 
-	importDone := make(chan bool, 1)
-	importDone <- true
+	importRecords(func() error {
+		return processFilesOrFolders(func(a string, b *linescanner.Scanner) error {
+			return processTextFileScanner(a, b, true)
+		})
+	})
+}
 
+// importRecords loads the records of every file that `process` processes into the database,
+// disabling the indexes of the database while loading and rebuilding them afterwards
+func importRecords(process func() error) {
 	var err error
 	errFile, err = os.OpenFile(c.FilePrefix+"err.log", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0664)
 	l.FatalOnErr("Opening error log", err)
@@ -108,50 +150,221 @@ func runImport(cmd *cobra.Command, filesOrFolders []string) {
 	l.FatalOnErr("Opening done log", err)
 	skipFile, err = os.OpenFile(c.FilePrefix+"skip.log", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0664)
 	l.FatalOnErr("Opening skip log", err)
-	outputFile, err = splitfilewriter.Create(c.FilePrefix+"tmp", ".csv", c.BatchSize)
-	l.FatalOnErr("Opening first output file", err)
-	outputFile.FullFileCallback = func(s *splitfilewriter.SplitFileWriter) error {
-		waitForImport(importDone)
-		go importToDatabase(s.CurrentFileName(), importDone)
-		return nil
-	}
+	quarantineFile, err = os.OpenFile(c.FilePrefix+"quarantine.log", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0664)
+	l.FatalOnErr("Opening quarantine log", err)
+	firstBatch := loadCheckpoint()
 
 	db, err = sql.Open("mysql", c.Conn+c.Database)
 	l.FatalOnErr("Opening main database connection", err)
 	sourcesDb, err = sql.Open("mysql", c.Conn+c.SourcesDatabase)
 	l.FatalOnErr("Opening sources database connection", err)
 
-	l.FatalOnErr("Setting engine", c.SetEngine(queryDatabaseEngine()))
+	openDatabaseEngine()
+	useInsert = c.Loader == "insert" || !loadDataAllowed()
+	var finishLoading func()
+	if c.Remote || useInsert {
+		finishLoading = streamBatches(firstBatch)
+	} else {
+		finishLoading = writeBatchFiles(firstBatch)
+	}
+	disableDatabaseIndexes()
+
+	err = process()
+	reportRepairedLines()
+	interrupted := err == errSignalInterrupt
+	if !interrupted {
+		l.FatalOnErr("Importing files", err)
+	}
+	l.WarnOnErr("Stopping external line parsers", parseline.CloseAll())
 
-	dataDir := getDataDir()
-	checkDatabaseToolsExist()
-	checkDatabaseFilePermissions(dataDir)
+	// final import to mysql, which includes the partial batch if the import was interrupted
+	finishLoading()
 
-	disableDatabaseIndexes(dataDir)
+	if !interrupted {
+		// everything has been loaded, so there is nothing to resume
+		err = checkpoint.Remove(c.FilePrefix + checkpointFileName)
+		l.WarnOnErr("Removing checkpoint file", err)
+	}
 
-	for _, path := range c.FilesOrFolders {
-		err := linescanner.LineScanner(path, func(a string, b *bufio.Scanner) error {
-			return processTextFileScanner(a, b, true)
-		})
-		if err == errSignalInterrupt {
-			return
+	if c.Compress && interrupted {
+		l.W("Not compressing the database because the import was interrupted. Compressed Aria and MyISAM tables are read-only, so compress it once the import is finished")
+	} else if c.Compress {
+		compressDatabase()
+	}
+	restoreDatabaseIndexes()
+
+	if interrupted {
+		l.I("The import was interrupted. Rerun the same command with --resume to continue it")
+	}
+	reportLoadThroughput()
+	if dbEngine.NeedsRestart() {
+		l.I("Please restart the MySQL server to allow using databases indexes")
+	}
+}
+
+// writeBatchFiles writes the records to tmp files, which the database server loads while the next file is written.
+// It returns a function that loads the final, partial batch
+func writeBatchFiles(firstBatch int) func() {
+	importDone := make(chan bool, 1)
+	importDone <- true
+
+	files, err := splitfilewriter.CreateAt(c.FilePrefix+"tmp", ".csv", firstBatch, c.BatchSize)
+	l.FatalOnErr("Opening first output file", err)
+	files.FullFileCallback = func(s *splitfilewriter.SplitFileWriter) error {
+		waitForImport(importDone)
+		go importToDatabase(s.CurrentFileName(), s.WriteCount, finishBatch(s.CurrentInc), importDone)
+		return nil
+	}
+	outputFile = files
+
+	return func() {
+		err := files.Flush()
+		l.FatalOnErr("Flushing the final output file", err)
+		waitForImport(importDone)
+		importToDatabase(files.CurrentFileName(), files.WriteCount, finishBatch(files.CurrentInc), importDone)
+	}
+}
+
+// streamBatches sends the records to the database server with LOAD DATA LOCAL INFILE, or INSERT statements, while they
+// are written, so no tmp files are written. It returns a function that loads the final, partial batch
+func streamBatches(firstBatch int) func() {
+	stream := splitstreamwriter.New(firstBatch, c.BatchSize, func(batch int, r io.Reader) error {
+		counter := &recordCounter{r: r}
+		start := time.Now()
+		if !useInsert {
+			l.I("Streaming batch " + strconv.Itoa(batch) + " to the database")
+			err := dbEngine.LoadReader(counter)
+			if err == nil {
+				recordLoad(loadLocalInfile, counter.records, time.Since(start))
+				return nil
+			}
+			// the server refuses before reading any records, so they can still be inserted
+			if !engine.IsLoadDataDenied(err) || counter.bytes > 0 {
+				return err
+			}
+			l.W("The database server doesn't allow LOAD DATA LOCAL INFILE (" + err.Error() + "). Loading with INSERT statements instead, which is slower")
+			useInsert = true
+			start = time.Now()
 		}
-		l.FatalOnErr("Importing "+path, err)
+
+		l.I("Inserting batch " + strconv.Itoa(batch) + " into the database")
+		if err := dbEngine.Insert(counter); err != nil {
+			return err
+		}
+		recordLoad(loadInsert, counter.records, time.Since(start))
+		return nil
+	})
+	stream.StreamDoneCallback = func(s *splitstreamwriter.SplitStreamWriter) error {
+		saveProgress(finishBatch(s.CurrentInc))
+		return nil
 	}
+	outputFile = stream
 
-	// final import to mysql
-	waitForImport(importDone)
-	importToDatabase(outputFile.CurrentFileName(), importDone)
+	return func() {
+		l.FatalOnErr("Loading the final batch into the database", stream.Close())
+	}
+}
 
-	flushAndLockTables()
+// names of the ways of loading batches, for the throughput summary
+const (
+	loadInfile      = "LOAD DATA INFILE"
+	loadLocalInfile = "LOAD DATA LOCAL INFILE"
+	loadInsert      = "INSERT statements"
+)
 
-	// TODO: customisable tmpDir
-	tmpDir := os.TempDir()
-	if c.Compress {
-		compressDatabase(dataDir, tmpDir)
+// useInsert is true once batches are loaded with INSERT statements. Batches are loaded one at a time, so it doesn't need
+// a lock
+var useInsert bool
+
+// loadDataAllowed checks the server settings that stop LOAD DATA from loading the batches. Other refusals, like a
+// missing FILE privilege, are detected when the first batch is loaded
+func loadDataAllowed() bool {
+	if c.Remote {
+		var localInfile string
+		err := db.QueryRow("SELECT @@local_infile").Scan(&localInfile)
+		l.FatalOnErr("Querying whether the database server allows LOAD DATA LOCAL INFILE", err)
+		if localInfile != "1" && !strings.EqualFold(localInfile, "on") {
+			l.W("The database server doesn't allow LOAD DATA LOCAL INFILE. Loading with INSERT statements instead, which is slower. Set local_infile=ON on the server to use LOAD DATA")
+			return false
+		}
+		return true
 	}
-	restoreDatabaseIndexes(dataDir, tmpDir)
 
-	unlockTables()
-	l.I("Please restart the MySQL server to allow using databases indexes")
+	var secureFilePriv sql.NullString
+	err := db.QueryRow("SELECT @@secure_file_priv").Scan(&secureFilePriv)
+	l.FatalOnErr("Querying the folder that LOAD DATA INFILE can read", err)
+	if !secureFilePriv.Valid {
+		l.W("The database server doesn't allow LOAD DATA INFILE (secure_file_priv is NULL). Loading with INSERT statements instead, which is slower")
+		return false
+	}
+	if secureFilePriv.String == "" {
+		return true
+	}
+
+	tmpFile, err := filepath.Abs(c.FilePrefix + "tmp")
+	l.FatalOnErr("Determining the absolute filepath of the tmp files", err)
+	allowed := filepath.Clean(secureFilePriv.String)
+	if rel, err := filepath.Rel(allowed, filepath.Dir(tmpFile)); err != nil || strings.HasPrefix(rel, "..") {
+		l.W("The database server only allows LOAD DATA INFILE from " + allowed + " (secure_file_priv). Loading with INSERT statements instead, which is slower. Use --filePrefix to write the tmp files there to use LOAD DATA")
+		return false
+	}
+	return true
+}
+
+// recordCounter counts the records and bytes that are read from a batch
+type recordCounter struct {
+	r       io.Reader
+	records int64
+	bytes   int64
+}
+
+func (rc *recordCounter) Read(p []byte) (int, error) {
+	n, err := rc.r.Read(p)
+	rc.bytes += int64(n)
+	rc.records += int64(bytes.Count(p[:n], []byte{'\n'}))
+	return n, err
+}
+
+// loadStat is the number of records that a way of loading batches has loaded, and how long it took
+type loadStat struct {
+	records int64
+	elapsed time.Duration
+}
+
+// loadStats are keyed by the name of the way of loading
+var loadStats = map[string]*loadStat{}
+
+func recordLoad(loader string, records int64, elapsed time.Duration) {
+	s, ok := loadStats[loader]
+	if !ok {
+		s = &loadStat{}
+		loadStats[loader] = s
+	}
+	s.records += records
+	s.elapsed += elapsed
+}
+
+// reportLoadThroughput logs how fast each way of loading batches was, and how much slower INSERT statements were than
+// LOAD DATA
+func reportLoadThroughput() {
+	var loadData, insert float64
+	for _, loader := range []string{loadInfile, loadLocalInfile, loadInsert} {
+		s, ok := loadStats[loader]
+		if !ok || s.elapsed <= 0 {
+			continue
+		}
+		rate := float64(s.records) / s.elapsed.Seconds()
+		l.I("Loaded " + strconv.FormatInt(s.records, 10) + " records with " + loader + " in " + s.elapsed.Round(time.Millisecond).String() + " (" + strconv.FormatFloat(rate, 'f', 0, 64) + " records/s)")
+		if loader == loadInsert {
+			insert = rate
+		} else {
+			loadData = rate
+		}
+	}
+
+	if loadData > 0 && insert > 0 {
+		l.I("INSERT statements were " + strconv.FormatFloat(loadData/insert, 'f', 1, 64) + "x slower than LOAD DATA")
+	} else if insert > 0 {
+		l.I("LOAD DATA is usually several times faster than INSERT statements. Allow it on the database server (secure_file_priv, local_infile and the FILE privilege) to speed up future imports")
+	}
 }
@@ -7,12 +7,11 @@ import (
 	"strings"
 	"time"
 
+	"github.com/darkmattermatt/dumpdb/internal/schema"
 	l "github.com/darkmattermatt/dumpdb/pkg/simplelog"
 	"github.com/spf13/cobra"
 )
 
-const schemaVersion = "0.0.4"
-
 // the `init` command
 var initCmd = &cobra.Command{
 	Use:   "init",
@@ -39,7 +38,7 @@ func init() {
 	initCmd.Flags().StringSliceP("databases", "d", []string{}, "comma separated list of databases to initialise")
 	initCmd.Flags().StringP("conn", "c", "", "connection string for the MySQL. Like user:pass@tcp(127.0.0.1:3306)")
 	initCmd.Flags().StringP("sourcesDatabase", "s", "", "initialise the sources database")
-	initCmd.Flags().String("engine", "aria", "the database engine. Aria is recommended (requires MariaDB), MyISAM is supported for MySQL")
+	initCmd.Flags().String("engine", "aria", "the database engine. Aria is recommended (requires MariaDB), MyISAM is supported for MySQL. InnoDB works with any server, including managed servers without access to the database files")
 	initCmd.Flags().StringSlice("indexes", []string{"email_rev"}, "comma separated list of columns to index in the main database. Email_rev is strongly recommended to enable searching by @email.com")
 
 	initCmd.MarkFlagRequired("conn")
@@ -62,7 +61,7 @@ func runInit(cmd *cobra.Command, databases []string) {
 	l.FatalOnErr("Opening connection to MySQL", err)
 
 	metadata := map[string]string{
-		"schema_version": schemaVersion,
+		"schema_version": schema.Version,
 		"created":        time.Now().Format("2006-01-02 15:04"),
 		"type":           "main",
 	}
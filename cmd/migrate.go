@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/darkmattermatt/dumpdb/internal/schema"
+	l "github.com/darkmattermatt/dumpdb/pkg/simplelog"
+	"github.com/spf13/cobra"
+)
+
+// the `migrate` command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade databases to the current schema version.",
+	Long:  "",
+	Run:   runMigrate,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		v.BindPFlags(cmd.Flags())
+	},
+	Args: func(cmd *cobra.Command, args []string) error {
+		databases, _ := cmd.Flags().GetStringSlice("databases")
+		sourcesDatabase, _ := cmd.Flags().GetString("sourcesDatabase")
+		if len(args) < 1 && len(databases) == 0 && sourcesDatabase == "" {
+			return errors.New("Missing database names to migrate")
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+
+	// Positional args: databases: the names of databases to migrate. Also support using -d flag
+	migrateCmd.Flags().StringSliceP("databases", "d", []string{}, "comma separated list of databases to migrate")
+	migrateCmd.Flags().StringP("conn", "c", "", "connection string for the MySQL. Like user:pass@tcp(127.0.0.1:3306)")
+	migrateCmd.Flags().StringP("sourcesDatabase", "s", "", "migrate the sources database")
+	migrateCmd.Flags().Bool("dryRun", false, "print the migrations that would run, without changing the databases")
+
+	migrateCmd.MarkFlagRequired("conn")
+}
+
+func loadMigrateConfig(cmd *cobra.Command, databases []string) {
+	l.FatalOnErr("Setting connection", c.SetConn(v.GetString("conn")))
+	l.FatalOnErr("Setting dry run", c.SetDryRun(v.GetBool("dryRun")))
+	c.Databases = append(v.GetStringSlice("databases"), databases...)
+	c.SourcesDatabase = v.GetString("sourcesDatabase")
+}
+
+func runMigrate(cmd *cobra.Command, databases []string) {
+	loadMigrateConfig(cmd, databases)
+
+	for _, dbName := range c.Databases {
+		migrateDatabase(dbName, schema.TypeMain)
+	}
+	if c.SourcesDatabase != "" {
+		migrateDatabase(c.SourcesDatabase, schema.TypeSources)
+	}
+}
+
+// migrateDatabase upgrades a database to the current schema version, or prints the plan if this is a dry run
+func migrateDatabase(dbName, dbType string) {
+	conn, err := sql.Open("mysql", c.Conn+dbName)
+	l.FatalOnErr("Opening connection to "+dbName, err)
+	defer conn.Close()
+
+	foundType, version, err := schema.ReadVersion(conn)
+	l.FatalOnErr("Reading the schema version of "+dbName, err)
+	if foundType != dbType {
+		l.F("The specified database " + dbName + " is not a DumpDB '" + dbType + "' database type")
+	}
+
+	plan, err := schema.Plan(version)
+	l.FatalOnErr("Planning the migration of "+dbName, err)
+	if len(plan) == 0 {
+		l.I(dbName + " is already at schema version " + schema.Version)
+		return
+	}
+
+	l.I("Migrating " + dbName + " from schema version " + version + " to " + schema.Version)
+	for _, m := range plan {
+		l.I("  " + m.Version + ": " + m.Description)
+		for _, stmt := range m.Statements(dbType) {
+			if c.DryRun {
+				l.R("    " + stmt + ";")
+			} else {
+				l.V("    " + stmt + ";")
+			}
+		}
+		if c.DryRun {
+			continue
+		}
+		l.FatalOnErr("Migrating "+dbName+" to schema version "+m.Version, schema.Apply(conn, dbType, m))
+	}
+
+	if c.DryRun {
+		l.I("Dry run: " + dbName + " was not changed")
+	}
+}
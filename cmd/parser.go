@@ -0,0 +1,271 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darkmattermatt/dumpdb/internal/linescanner"
+	"github.com/darkmattermatt/dumpdb/internal/parseline"
+	l "github.com/darkmattermatt/dumpdb/pkg/simplelog"
+	"github.com/spf13/cobra"
+)
+
+// the `parser` command
+var parserCmd = &cobra.Command{
+	Use:   "parser",
+	Short: "Work with line parsers.",
+	Long:  "",
+}
+
+// the `parser test` command
+var parserTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Preview and profile a line parser on files or folders, without touching any database.",
+	Long:  "",
+	Run:   runParserTest,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		v.BindPFlags(cmd.Flags())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(parserCmd)
+	parserCmd.AddCommand(parserTestCmd)
+
+	// Positional args: filesOrFolders: files and/or folders to test. Use - to read from stdin
+	parserTestCmd.Flags().StringP("parser", "p", "", "the line parser to test. Auto detects the line parser of each file")
+	parserTestCmd.Flags().StringSlice("parserMap", []string{}, "comma separated list of glob=parser, to use a different line parser for matching files, e.g. **/adobe*/*.txt=adobe. The first match wins, otherwise --parser is used")
+	parserTestCmd.Flags().Int("autoSample", 100, "number of lines of each file to sample when the parser is auto")
+	parserTestCmd.Flags().Float64("autoThreshold", 0.5, "minimum score (0-1) of the best line parser when the parser is auto. Files below it are skipped")
+	parserTestCmd.Flags().IntP("lines", "n", 1000, "number of lines of each file to parse. 0 parses every line")
+	parserTestCmd.Flags().Int("show", 10, "number of parsed lines of each file to print")
+	parserTestCmd.Flags().String("sourceName", "", "template of the source name of each record, with any of {source} {path} {archive} {member} {dirname} {basename} {database} {parser}, e.g. {archive}/{member}. Standard input is named stdin (default \"{source}\", the name from the line parser or the file path)")
+	parserTestCmd.Flags().StringSlice("include", []string{}, "comma separated list of globs that files inside folders must match, e.g. *.txt,**/data/*.csv")
+	parserTestCmd.Flags().StringSlice("exclude", []string{}, "comma separated list of globs of files and folders inside folders to skip")
+	parserTestCmd.Flags().Int("maxDepth", 5, "maximum number of nested archives to open. 1 opens archives but not archives inside them")
+	parserTestCmd.Flags().Int64("maxDecompressedSize", 256<<30, "maximum number of bytes to decompress from a single file, to guard against zip bombs. 0 means no limit")
+	parserTestCmd.Flags().String("encoding", "auto", "character encoding of the text files, e.g. utf-8, utf-16le, latin1, cp1251. Auto detects the encoding of each file")
+	parserTestCmd.Flags().Int("maxLineLength", 1024*1024, "maximum number of bytes in a line. Longer lines are skipped")
+	parserTestCmd.Flags().Bool("sniff", true, "skip files that look like binary files from their first few KB")
+
+	parserTestCmd.MarkFlagRequired("parser")
+}
+
+func loadParserTestConfig(cmd *cobra.Command, filesOrFolders []string) {
+	l.FatalOnErr("Setting line parser", c.SetLineParser(v.GetString("parser")))
+	l.FatalOnErr("Setting parser map", c.SetParserMap(v.GetStringSlice("parserMap")))
+	l.FatalOnErr("Setting auto parser sample size", c.SetAutoSample(v.GetInt("autoSample")))
+	l.FatalOnErr("Setting auto parser threshold", c.SetAutoThreshold(v.GetFloat64("autoThreshold")))
+	l.FatalOnErr("Setting number of lines", c.SetTestLines(v.GetInt("lines")))
+	l.FatalOnErr("Setting number of lines to show", c.SetTestShow(v.GetInt("show")))
+	l.FatalOnErr("Setting include globs", c.SetInclude(v.GetStringSlice("include")))
+	l.FatalOnErr("Setting exclude globs", c.SetExclude(v.GetStringSlice("exclude")))
+	l.FatalOnErr("Setting maximum archive depth", c.SetMaxDepth(v.GetInt("maxDepth")))
+	l.FatalOnErr("Setting maximum decompressed size", c.SetMaxSize(v.GetInt64("maxDecompressedSize")))
+	l.FatalOnErr("Setting encoding", c.SetEncoding(v.GetString("encoding")))
+	l.FatalOnErr("Setting maximum line length", c.SetMaxLineLength(v.GetInt("maxLineLength")))
+	l.FatalOnErr("Setting sniff", c.SetSniff(v.GetBool("sniff")))
+	l.FatalOnErr("Setting source name", c.SetSourceName(v.GetString("sourceName")))
+	l.FatalOnErr("Setting files or folders", c.SetFilesOrFolders(filesOrFolders))
+}
+
+// parserStats counts the results of parsing lines
+type parserStats struct {
+	lines  int64
+	bytes  int64
+	parsed int64
+	errors map[string]int64
+	filled map[string]int64
+}
+
+// recordFields returns the values of the Record fields that are stored in the database, by name
+func recordFields(r parseline.Record) [][2]string {
+	email := r.Email
+	if email == "" && r.EmailRev != "" {
+		email = "(reversed) " + r.EmailRev
+	}
+	return [][2]string{
+		{"source", r.Source},
+		{"username", r.Username},
+		{"email", email},
+		{"hash", r.Hash},
+		{"password", r.Password},
+		{"extra", r.Extra},
+	}
+}
+
+func runParserTest(cmd *cobra.Command, filesOrFolders []string) {
+	loadParserTestConfig(cmd, filesOrFolders)
+
+	stats := parserStats{
+		errors: make(map[string]int64),
+		filled: make(map[string]int64),
+	}
+	start := time.Now()
+
+	err := processFilesOrFolders(func(path string, lineScanner *linescanner.Scanner) error {
+		return testLineParser(path, lineScanner, &stats)
+	})
+	if err != errSignalInterrupt {
+		l.FatalOnErr("Testing line parser", err)
+	}
+	l.WarnOnErr("Stopping external line parsers", parseline.CloseAll())
+
+	reportParserStats(stats, time.Since(start))
+}
+
+// testLineParser parses the lines of a single file, printing the first few records next to their lines
+func testLineParser(path string, lineScanner lineReader, stats *parserStats) error {
+	parser := lineParserFor(path)
+	if parser == parseline.Auto {
+		var sample []string
+		var err error
+		lineScanner, sample, err = sampleLineScanner(lineScanner, c.AutoSample)
+		if err != nil {
+			return err
+		}
+
+		reportParserScores(path, sample)
+		var ok bool
+		parser, ok = detectLineParser(path, sample)
+		if !ok {
+			return nil
+		}
+	}
+
+	lineParser, err := parseline.Stream(parser)
+	if err != nil {
+		return err
+	}
+
+	sourceName := sourceNamer(path, lineScanner, parser)
+	var shown int
+	emit := func(line string, lineNum int64, r parseline.Record, err error) {
+		if err == nil {
+			err = r.Check()
+		}
+		show := shown < c.TestShow
+		if show {
+			shown++
+			l.R(fmt.Sprintf("%6d  %s", lineNum, line))
+		}
+		if err != nil {
+			stats.errors[err.Error()]++
+			if show {
+				l.R("        error: " + err.Error())
+			}
+			return
+		}
+
+		stats.parsed++
+		r.Source = sourceName(r.Source)
+		var fields []string
+		for _, f := range recordFields(r) {
+			if f[1] != "" {
+				stats.filled[f[0]]++
+				fields = append(fields, f[0]+"="+strconv.Quote(f[1]))
+			}
+		}
+		if show {
+			l.R("        " + strings.Join(fields, " "))
+		}
+	}
+
+	l.R("==> " + path + " (" + parser + ") <==")
+	var n int
+	for lineScanner.Scan() {
+		line, lineNum := lineScanner.Text(), lineScanner.Line()
+
+		// CTRL+C means stop
+		if signalCaught() {
+			l.WarnOnErr("Finishing line parser", lineParser.Flush())
+			return errSignalInterrupt
+		}
+		if line == "" {
+			continue
+		}
+		if c.TestLines > 0 && n >= c.TestLines {
+			break
+		}
+		n++
+
+		stats.lines++
+		stats.bytes += int64(len(line)) + 1
+		if err := lineParser.Parse(line, path, lineNum, emit); err != nil {
+			return err
+		}
+	}
+	if err := lineParser.Flush(); err != nil {
+		return err
+	}
+	return lineScanner.Err()
+}
+
+// reportParserScores prints the score of every line parser on the sampled lines, best first
+func reportParserScores(path string, sample []string) {
+	if len(sample) == 0 {
+		return
+	}
+
+	names := parseline.Parsers()
+	scores := make(map[string]float64, len(names))
+	for _, name := range names {
+		scores[name] = parseline.Score(name, sample)
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return scores[names[i]] > scores[names[j]]
+	})
+
+	arr := make([]string, len(names))
+	for i, name := range names {
+		arr[i] = name + " " + strconv.FormatFloat(scores[name], 'f', 2, 64)
+	}
+	l.V("Line parser scores for " + path + ": " + strings.Join(arr, ", "))
+}
+
+// reportParserStats prints the success rate, error reasons, field fill rates and throughput
+func reportParserStats(stats parserStats, elapsed time.Duration) {
+	if stats.lines == 0 {
+		l.R("No lines were parsed")
+		return
+	}
+
+	// a line may contain several records, or part of a record, so rates are of the results instead of the lines
+	var failed int64
+	for _, n := range stats.errors {
+		failed += n
+	}
+	percentOf := func(n, total int64) string {
+		if total == 0 {
+			return "0.0%"
+		}
+		return strconv.FormatFloat(100*float64(n)/float64(total), 'f', 1, 64) + "%"
+	}
+	seconds := elapsed.Seconds()
+
+	l.R("")
+	l.R(fmt.Sprintf("Parsed %d records (%s) and %d errors from %d lines in %s, %.0f lines/s, %.1f MB/s", stats.parsed, percentOf(stats.parsed, stats.parsed+failed), failed, stats.lines, elapsed.Round(time.Millisecond), float64(stats.lines)/seconds, float64(stats.bytes)/seconds/1e6))
+
+	if len(stats.errors) > 0 {
+		reasons := make([]string, 0, len(stats.errors))
+		for reason := range stats.errors {
+			reasons = append(reasons, reason)
+		}
+		sort.Slice(reasons, func(i, j int) bool {
+			return stats.errors[reasons[i]] > stats.errors[reasons[j]]
+		})
+
+		l.R("Errors:")
+		for _, reason := range reasons {
+			l.R(fmt.Sprintf("  %7s  %s", percentOf(stats.errors[reason], stats.parsed+failed), reason))
+		}
+	}
+
+	l.R("Fields:")
+	for _, f := range recordFields(parseline.Record{}) {
+		l.R(fmt.Sprintf("  %7s  %s", percentOf(stats.filled[f[0]], stats.parsed), f[0]))
+	}
+}
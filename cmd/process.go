@@ -1,11 +1,11 @@
 package cmd
 
 import (
-	"bufio"
 	"os"
 	"time"
 
 	"github.com/darkmattermatt/dumpdb/internal/linescanner"
+	"github.com/darkmattermatt/dumpdb/internal/parseline"
 	l "github.com/darkmattermatt/dumpdb/pkg/simplelog"
 	"github.com/darkmattermatt/dumpdb/pkg/splitfilewriter"
 	"github.com/spf13/cobra"
@@ -25,8 +25,22 @@ var processCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(processCmd)
 
-	// Positional args: filesOrFolders: files and/or folders to import
-	processCmd.Flags().StringP("parser", "p", "", "the custom line parser to use. Modify the internal/parseline package to add another line parser")
+	// Positional args: filesOrFolders: files and/or folders to import. Use - to read from stdin
+	processCmd.Flags().StringP("parser", "p", "", "the line parser to use. Define another line parser under parsers in the config file or in the internal/parseline package. Auto detects the line parser of each file")
+	processCmd.Flags().StringSlice("parserMap", []string{}, "comma separated list of glob=parser, to use a different line parser for matching files, e.g. **/adobe*/*.txt=adobe. The first match wins, otherwise --parser is used")
+	processCmd.Flags().Int("autoSample", 100, "number of lines of each file to sample when the parser is auto")
+	processCmd.Flags().Float64("autoThreshold", 0.5, "minimum score (0-1) of the best line parser when the parser is auto. Files below it are skipped")
+	processCmd.Flags().String("sourceName", "", "template of the source name of each record, with any of {source} {path} {archive} {member} {dirname} {basename} {database} {parser}, e.g. {archive}/{member}. Standard input is named stdin (default \"{source}\", the name from the line parser or the file path)")
+	processCmd.Flags().StringSlice("include", []string{}, "comma separated list of globs that files inside folders must match, e.g. *.txt,**/data/*.csv")
+	processCmd.Flags().StringSlice("exclude", []string{}, "comma separated list of globs of files and folders inside folders to skip")
+	processCmd.Flags().Bool("followSymlinks", false, "follow symbolic links inside folders")
+	processCmd.Flags().Int("maxDepth", 5, "maximum number of nested archives to open. 1 opens archives but not archives inside them")
+	processCmd.Flags().Int64("maxDecompressedSize", 256<<30, "maximum number of bytes to decompress from a single file, to guard against zip bombs. 0 means no limit")
+	processCmd.Flags().String("encoding", "auto", "character encoding of the text files, e.g. utf-8, utf-16le, latin1, cp1251. Auto detects the encoding of each file")
+	processCmd.Flags().Int("maxLineLength", 1024*1024, "maximum number of bytes in a line. Longer lines are skipped and written to the quarantine log")
+	processCmd.Flags().Bool("sniff", true, "skip files that look like binary files from their first few KB")
+	processCmd.Flags().StringSlice("allowExtensions", []string{}, "comma separated list of file extensions to process, e.g. .txt,.csv. Empty allows any extension")
+	processCmd.Flags().StringSlice("denyExtensions", defaultDenyExtensions, "comma separated list of file extensions to skip")
 	processCmd.Flags().Int("batchSize", 4e6, "number of lines per temporary file (used for the LOAD FILE INTO command). 1e6 = ~64MB, 16e6 = ~1GB")
 	processCmd.Flags().String("filePrefix", time.Now().Format("2006-01-02_1504_05 "), "processed file prefix")
 
@@ -37,6 +51,20 @@ func loadProcessConfig(cmd *cobra.Command, filesOrFolders []string) {
 	l.FatalOnErr("Setting batch size", c.SetBatchSize(v.GetInt("batchSize")))
 	l.FatalOnErr("Setting file prefix", c.SetFilePrefix(v.GetString("filePrefix")))
 	l.FatalOnErr("Setting line parser", c.SetLineParser(v.GetString("parser")))
+	l.FatalOnErr("Setting parser map", c.SetParserMap(v.GetStringSlice("parserMap")))
+	l.FatalOnErr("Setting auto parser sample size", c.SetAutoSample(v.GetInt("autoSample")))
+	l.FatalOnErr("Setting auto parser threshold", c.SetAutoThreshold(v.GetFloat64("autoThreshold")))
+	l.FatalOnErr("Setting include globs", c.SetInclude(v.GetStringSlice("include")))
+	l.FatalOnErr("Setting exclude globs", c.SetExclude(v.GetStringSlice("exclude")))
+	l.FatalOnErr("Setting follow symlinks", c.SetFollowSymlinks(v.GetBool("followSymlinks")))
+	l.FatalOnErr("Setting maximum archive depth", c.SetMaxDepth(v.GetInt("maxDepth")))
+	l.FatalOnErr("Setting maximum decompressed size", c.SetMaxSize(v.GetInt64("maxDecompressedSize")))
+	l.FatalOnErr("Setting encoding", c.SetEncoding(v.GetString("encoding")))
+	l.FatalOnErr("Setting maximum line length", c.SetMaxLineLength(v.GetInt("maxLineLength")))
+	l.FatalOnErr("Setting sniff", c.SetSniff(v.GetBool("sniff")))
+	l.FatalOnErr("Setting allowed extensions", c.SetAllowExtensions(v.GetStringSlice("allowExtensions")))
+	l.FatalOnErr("Setting denied extensions", c.SetDenyExtensions(v.GetStringSlice("denyExtensions")))
+	l.FatalOnErr("Setting source name", c.SetSourceName(v.GetString("sourceName")))
 	l.FatalOnErr("Setting files or folders", c.SetFilesOrFolders(filesOrFolders))
 }
 
@@ -50,20 +78,25 @@ func runProcess(cmd *cobra.Command, filesOrFolders []string) {
 	l.FatalOnErr("Opening done log", err)
 	skipFile, err = os.OpenFile(c.FilePrefix+"skip.log", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0664)
 	l.FatalOnErr("Opening skip log", err)
-	outputFile, err = splitfilewriter.Create(c.FilePrefix+"output", ".csv", c.BatchSize)
+	quarantineFile, err = os.OpenFile(c.FilePrefix+"quarantine.log", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0664)
+	l.FatalOnErr("Opening quarantine log", err)
+	files, err := splitfilewriter.Create(c.FilePrefix+"output", ".csv", c.BatchSize)
 	l.FatalOnErr("Opening first output file", err)
-	outputFile.FullFileCallback = func(s *splitfilewriter.SplitFileWriter) error {
+	outputFile = files
+	files.FullFileCallback = func(s *splitfilewriter.SplitFileWriter) error {
 		l.D("Beginning to write to " + s.NextFileName())
 		return nil
 	}
 
-	for _, path := range c.FilesOrFolders {
-		err := linescanner.LineScanner(path, func(a string, b *bufio.Scanner) error {
-			return processTextFileScanner(a, b, false)
-		})
-		if err == errSignalInterrupt {
-			return
-		}
-		l.FatalOnErr("Processing "+path, err)
+	err = processFilesOrFolders(func(a string, b *linescanner.Scanner) error {
+		return processTextFileScanner(a, b, false)
+	})
+	reportRepairedLines()
+	if err != errSignalInterrupt {
+		l.FatalOnErr("Processing files", err)
 	}
+	l.WarnOnErr("Stopping external line parsers", parseline.CloseAll())
+
+	err = files.Flush()
+	l.FatalOnErr("Flushing the final output file", err)
 }
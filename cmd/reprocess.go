@@ -0,0 +1,380 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/darkmattermatt/dumpdb/internal/errlog"
+	"github.com/darkmattermatt/dumpdb/internal/linescanner"
+	l "github.com/darkmattermatt/dumpdb/pkg/simplelog"
+	"github.com/spf13/cobra"
+)
+
+// the `reprocess` command
+var reprocessCmd = &cobra.Command{
+	Use:   "reprocess",
+	Short: "Import the lines of error logs, or the files of skip logs, again with a different line parser.",
+	Long:  "",
+	Args:  cobra.NoArgs,
+	Run:   runReprocess,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		v.BindPFlags(cmd.Flags())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reprocessCmd)
+
+	reprocessCmd.Flags().StringSlice("errLog", []string{}, "comma separated list of error logs whose lines are imported again")
+	reprocessCmd.Flags().StringSlice("skipLog", []string{}, "comma separated list of skip logs whose files are imported again")
+	reprocessCmd.Flags().String("matchError", "", "regex that the error of a line (or the reason a file was skipped) must match to be imported again, e.g. ^Incorrect number of columns")
+	reprocessCmd.Flags().String("fromParser", "", "only import the lines of the error logs that failed with this line parser")
+	reprocessCmd.Flags().StringP("parser", "p", "", "the line parser to use. Define another line parser under parsers in the config file or in the internal/parseline package. Auto detects the line parser of each file")
+	reprocessCmd.Flags().StringSlice("parserMap", []string{}, "comma separated list of glob=parser, to use a different line parser for matching files, e.g. **/adobe*/*.txt=adobe. The first match wins, otherwise --parser is used")
+	reprocessCmd.Flags().Int("autoSample", 100, "number of lines of each file to sample when the parser is auto")
+	reprocessCmd.Flags().Float64("autoThreshold", 0.5, "minimum score (0-1) of the best line parser when the parser is auto. Files below it are skipped")
+	reprocessCmd.Flags().String("sourceName", "", "template of the source name of each record, with any of {source} {path} {archive} {member} {dirname} {basename} {database} {parser}, e.g. {archive}/{member}. Standard input is named stdin (default \"{source}\", the name from the line parser or the file path)")
+	reprocessCmd.Flags().Int("maxDepth", 5, "maximum number of nested archives to open. 1 opens archives but not archives inside them")
+	reprocessCmd.Flags().Int64("maxDecompressedSize", 256<<30, "maximum number of bytes to decompress from a single file, to guard against zip bombs. 0 means no limit")
+	reprocessCmd.Flags().String("encoding", "auto", "character encoding of the text files, e.g. utf-8, utf-16le, latin1, cp1251. Auto detects the encoding of each file")
+	reprocessCmd.Flags().Int("maxLineLength", 1024*1024, "maximum number of bytes in a line. Longer lines are skipped and written to the quarantine log")
+	reprocessCmd.Flags().Bool("sniff", true, "skip files that look like binary files from their first few KB")
+	reprocessCmd.Flags().StringSlice("allowExtensions", []string{}, "comma separated list of file extensions to process, e.g. .txt,.csv. Empty allows any extension")
+	reprocessCmd.Flags().StringSlice("denyExtensions", defaultDenyExtensions, "comma separated list of file extensions to skip")
+	reprocessCmd.Flags().StringP("conn", "c", "", "connection string for the SQL database. Like user:pass@tcp(127.0.0.1:3306)")
+	reprocessCmd.Flags().StringP("database", "d", "", "database name to import into")
+	reprocessCmd.Flags().StringP("sourcesDatabase", "s", "", "database name to store sources in")
+	reprocessCmd.Flags().Bool("compress", false, "compress the database after importing. Aria and MyISAM tables are packed into a read-only format, InnoDB tables use page compression")
+
+	reprocessCmd.Flags().Int("batchSize", 4e6, "number of lines per temporary file (used for the LOAD FILE INTO command). 1e6 = ~64MB, 16e6 = ~1GB")
+	reprocessCmd.Flags().Bool("remote", false, "stream each batch to the database server with LOAD DATA LOCAL INFILE instead of writing tmp files, and only change the database through SQL. For database servers on other hosts")
+	reprocessCmd.Flags().String("loader", "auto", "how batches are loaded: auto uses LOAD DATA, and multi-row INSERT statements if the database server doesn't allow LOAD DATA. insert always uses INSERT statements")
+	reprocessCmd.Flags().Int("insertBatchSize", 1000, "number of rows of each INSERT statement, when batches are loaded with INSERT statements")
+	reprocessCmd.Flags().Int("insertConcurrency", 4, "number of connections that send INSERT statements at once")
+	reprocessCmd.Flags().StringP("filePrefix", "o", "[database]_reprocess_", "temporary processed file prefix")
+	reprocessCmd.Flags().Bool("resume", false, "skip files listed in the done log and continue from the last checkpoint of an interrupted reprocess")
+
+	reprocessCmd.MarkFlagRequired("parser")
+	reprocessCmd.MarkFlagRequired("conn")
+	reprocessCmd.MarkFlagRequired("database")
+	reprocessCmd.MarkFlagRequired("sourcesDatabase")
+}
+
+func loadReprocessConfig(cmd *cobra.Command) {
+	l.FatalOnErr("Setting error logs", c.SetErrLogs(v.GetStringSlice("errLog")))
+	l.FatalOnErr("Setting skip logs", c.SetSkipLogs(v.GetStringSlice("skipLog")))
+	if len(c.ErrLogs) == 0 && len(c.SkipLogs) == 0 {
+		showUsage(cmd, "At least one --errLog or --skipLog is required")
+	}
+	l.FatalOnErr("Setting error regex", c.SetMatchError(v.GetString("matchError")))
+	l.FatalOnErr("Setting failed line parser", c.SetFromParser(v.GetString("fromParser")))
+
+	l.FatalOnErr("Setting connection", c.SetConn(v.GetString("conn")))
+	l.FatalOnErr("Setting database", c.SetDatabase(v.GetString("database")))
+	l.FatalOnErr("Setting sources database", c.SetSourcesDatabase(v.GetString("sourcesDatabase")))
+
+	l.FatalOnErr("Setting compress", c.SetCompress(v.GetBool("compress")))
+	l.FatalOnErr("Setting batch size", c.SetBatchSize(v.GetInt("batchSize")))
+	l.FatalOnErr("Setting file prefix", c.SetFilePrefix(v.GetString("filePrefix")))
+	l.FatalOnErr("Setting resume", c.SetResume(v.GetBool("resume")))
+	l.FatalOnErr("Setting remote", c.SetRemote(v.GetBool("remote")))
+	l.FatalOnErr("Setting loader", c.SetLoader(v.GetString("loader")))
+	l.FatalOnErr("Setting insert batch size", c.SetInsertBatchSize(v.GetInt("insertBatchSize")))
+	l.FatalOnErr("Setting insert concurrency", c.SetInsertConcurrency(v.GetInt("insertConcurrency")))
+
+	l.FatalOnErr("Setting line parser", c.SetLineParser(v.GetString("parser")))
+	l.FatalOnErr("Setting parser map", c.SetParserMap(v.GetStringSlice("parserMap")))
+	l.FatalOnErr("Setting auto parser sample size", c.SetAutoSample(v.GetInt("autoSample")))
+	l.FatalOnErr("Setting auto parser threshold", c.SetAutoThreshold(v.GetFloat64("autoThreshold")))
+	l.FatalOnErr("Setting maximum archive depth", c.SetMaxDepth(v.GetInt("maxDepth")))
+	l.FatalOnErr("Setting maximum decompressed size", c.SetMaxSize(v.GetInt64("maxDecompressedSize")))
+	l.FatalOnErr("Setting encoding", c.SetEncoding(v.GetString("encoding")))
+	l.FatalOnErr("Setting maximum line length", c.SetMaxLineLength(v.GetInt("maxLineLength")))
+	l.FatalOnErr("Setting sniff", c.SetSniff(v.GetBool("sniff")))
+	l.FatalOnErr("Setting allowed extensions", c.SetAllowExtensions(v.GetStringSlice("allowExtensions")))
+	l.FatalOnErr("Setting denied extensions", c.SetDenyExtensions(v.GetStringSlice("denyExtensions")))
+	l.FatalOnErr("Setting source name", c.SetSourceName(v.GetString("sourceName")))
+
+	// the logs of this run are appended to while the input logs are read
+	for _, name := range []string{"err.log", "skip.log"} {
+		output, err := filepath.Abs(c.FilePrefix + name)
+		l.FatalOnErr("Determining the absolute filepath of "+c.FilePrefix+name, err)
+		for _, path := range append(append([]string{}, c.ErrLogs...), c.SkipLogs...) {
+			input, err := filepath.Abs(path)
+			l.FatalOnErr("Determining the absolute filepath of "+path, err)
+			if input == output {
+				l.F("Cannot reprocess " + path + " because it is also written to by this command. Use a different --filePrefix")
+			}
+		}
+	}
+}
+
+func runReprocess(cmd *cobra.Command, args []string) {
+	loadReprocessConfig(cmd)
+	setRecoveryNote("To continue reprocessing, rerun the same command with --resume")
+
+	importRecords(reprocessLogs)
+}
+
+// reprocessLogs imports the lines of the error logs, then the files of the skip logs
+func reprocessLogs() error {
+	configureLineScanner()
+
+	for _, path := range c.ErrLogs {
+		if err := reprocessErrLog(path); err != nil {
+			return err
+		}
+	}
+	for _, path := range c.SkipLogs {
+		if err := reprocessSkipLog(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reprocessErrLog imports the matching lines of an error log, grouped by the file they came from
+func reprocessErrLog(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	l.I("Reprocessing the lines of " + path)
+	lines := &errLogLines{log: errlog.NewReader(f)}
+	for lines.nextFile() {
+		err := processTextFileScanner(lines.source, lines, true)
+		if err == errSignalInterrupt {
+			return err
+		} else if err != nil {
+			return fmt.Errorf("%s: %v", lines.source, err)
+		}
+	}
+	if lines.err != nil {
+		return fmt.Errorf("%s: %v", path, lines.err)
+	}
+	l.V("Reprocessed " + strconv.FormatInt(lines.matched, 10) + " entries of " + path)
+	return nil
+}
+
+// errLogLines reads the lines of the entries of an error log as if they were the lines of their files.
+// The entries of each file are read until an entry of a different file is found, then nextFile moves on to that file
+type errLogLines struct {
+	log     *errlog.Reader
+	err     error
+	matched int64
+
+	// next is the entry that was read after the last line of the current file
+	next    *errlog.Entry
+	source  string
+	archive string
+	member  string
+
+	lines []sampledLine
+	cur   sampledLine
+}
+
+// read returns the next entry that matches --matchError and --fromParser, or false at the end of the log
+func (r *errLogLines) read() (errlog.Entry, bool) {
+	for {
+		e, err := r.log.Next()
+		if err != nil {
+			if err != io.EOF {
+				r.err = err
+			}
+			return errlog.Entry{}, false
+		}
+		if c.MatchError != nil && !c.MatchError.MatchString(e.Error) {
+			continue
+		}
+		if c.FromParser != "" && !strings.EqualFold(e.Parser, c.FromParser) {
+			continue
+		}
+		r.matched++
+		return e, true
+	}
+}
+
+// nextFile skips the rest of the current file, and starts reading the lines of the next one
+func (r *errLogLines) nextFile() bool {
+	for r.Scan() {
+	}
+	if r.err != nil {
+		return false
+	}
+	if r.next == nil {
+		e, ok := r.read()
+		if !ok {
+			return false
+		}
+		r.next = &e
+	}
+
+	r.source = r.next.Source
+	r.archive, r.member = "", r.source
+	if file, member, ok := inputFile(r.source); ok && member != "" {
+		r.archive, r.member = file, member
+	}
+	return true
+}
+
+func (r *errLogLines) Scan() bool {
+	for len(r.lines) == 0 {
+		if r.err != nil {
+			return false
+		}
+		if r.next == nil {
+			e, ok := r.read()
+			if !ok {
+				return false
+			}
+			r.next = &e
+		}
+		if r.next.Source != r.source {
+			return false
+		}
+		r.lines = entryLines(*r.next)
+		r.next = nil
+	}
+	r.cur, r.lines = r.lines[0], r.lines[1:]
+	return true
+}
+
+func (r *errLogLines) Text() string {
+	return r.cur.text
+}
+
+func (r *errLogLines) Line() int64 {
+	return r.cur.line
+}
+
+func (r *errLogLines) Offset() int64 {
+	return r.cur.offset
+}
+
+func (r *errLogLines) Err() error {
+	return r.err
+}
+
+func (r *errLogLines) Oversized() int64 {
+	return 0
+}
+
+func (r *errLogLines) Archive() string {
+	return r.archive
+}
+
+func (r *errLogLines) Member() string {
+	return r.member
+}
+
+// entryLines splits the text of an entry into its lines. Records of stream parsers can span several lines, which end at
+// the line number of the entry. Only the offset of the last line is known
+func entryLines(e errlog.Entry) []sampledLine {
+	texts := strings.Split(e.Text, "\n")
+	first := e.Line - int64(len(texts)-1)
+	lines := make([]sampledLine, len(texts))
+	for i, text := range texts {
+		lines[i] = sampledLine{text: text, line: first + int64(i), offset: -1}
+	}
+	lines[len(lines)-1].offset = e.Offset
+	return lines
+}
+
+// reprocessSkipLog imports the matching files of a skip log. Files inside archives are read by opening the archive
+func reprocessSkipLog(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	skipped, err := errlog.ReadSkipped(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	// group the skipped files by the file on disk that they are in, so that each archive is only opened once
+	var files []string
+	wanted := map[string][]string{}
+	for _, s := range skipped {
+		if c.MatchError != nil && !c.MatchError.MatchString(s.Reason) {
+			continue
+		}
+		file, _, ok := inputFile(s.Path)
+		if !ok {
+			l.W("Not reprocessing " + s.Path + ": the file does not exist")
+			continue
+		}
+		if _, ok := wanted[file]; !ok {
+			files = append(files, file)
+		}
+		wanted[file] = append(wanted[file], s.Path)
+	}
+
+	l.I("Reprocessing the files of " + path)
+	for _, file := range files {
+		names := wanted[file]
+		err := linescanner.LineScanner(file, func(name string, s *linescanner.Scanner) error {
+			if !isSkippedName(name, names) {
+				return nil
+			}
+			return processTextFileScanner(name, s, true)
+		})
+		if err == errSignalInterrupt {
+			return err
+		} else if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	return nil
+}
+
+// isSkippedName checks if a file is one of the skipped files, or inside one of them (e.g. an archive that was too deep)
+func isSkippedName(name string, skipped []string) bool {
+	for _, s := range skipped {
+		if name == s || strings.HasPrefix(name, s+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// compressedExts are the extensions that linescanner removes from the names of compressed text files
+var compressedExts = []string{".gz", ".gzip", ".bz2", ".bzip2", ".zz", ".zlib"}
+
+// inputFile finds the file on disk that a file named by linescanner was read from, and the path of the file inside it
+// if it is in an archive. Names of compressed text files don't have the compression extension, e.g. users.txt.gz is
+// named users.txt and logs.tgz is named logs.tar
+func inputFile(name string) (file, member string, ok bool) {
+	candidates := []string{name}
+	for _, ext := range compressedExts {
+		candidates = append(candidates, name+ext)
+	}
+	if strings.HasSuffix(name, ".tar") {
+		base := strings.TrimSuffix(name, ".tar")
+		candidates = append(candidates, base+".tgz", base+".tbz", base+".tbz2")
+	}
+	for _, candidate := range candidates {
+		if isRegularFile(candidate) {
+			return candidate, "", true
+		}
+	}
+
+	// archive members are named by joining the archive name and the member name
+	for i := strings.LastIndex(name, "/"); i > 0; i = strings.LastIndex(name[:i], "/") {
+		if isRegularFile(name[:i]) {
+			return name[:i], name[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+func isRegularFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
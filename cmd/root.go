@@ -3,13 +3,19 @@ package cmd
 import (
 	"database/sql"
 	"errors"
+	"io"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
 
+	"github.com/darkmattermatt/dumpdb/internal/checkpoint"
 	"github.com/darkmattermatt/dumpdb/internal/config"
+	"github.com/darkmattermatt/dumpdb/internal/engine"
+	"github.com/darkmattermatt/dumpdb/internal/parseline"
 	"github.com/darkmattermatt/dumpdb/pkg/camelcase2underscore"
 	"github.com/darkmattermatt/dumpdb/pkg/simplelog"
-	"github.com/darkmattermatt/dumpdb/pkg/splitfilewriter"
 	_ "github.com/go-sql-driver/mysql" // import driver for `sql`
 
 	l "github.com/darkmattermatt/dumpdb/pkg/simplelog"
@@ -25,6 +31,9 @@ const (
 	metadataTable = "metadata"
 )
 
+// defaultDenyExtensions are common binary file extensions that are never processed as text
+var defaultDenyExtensions = []string{".exe", ".dll", ".so", ".bin", ".jpg", ".jpeg", ".png", ".gif", ".bmp", ".pdf", ".doc", ".docx", ".xls", ".xlsx", ".mp3", ".mp4", ".avi", ".mkv", ".iso"}
+
 var errSignalInterrupt = errors.New("Signal Interrupt")
 
 var v = viper.NewWithOptions(viper.EnvKeyReplacer(camelcase2underscore.NewReplacer()))
@@ -37,16 +46,58 @@ var rootCmd = &cobra.Command{
 }
 
 var (
-	signalInterrupt bool
-	doneFile        *os.File
-	skipFile        *os.File
-	errFile         *os.File
-	outputFile      *splitfilewriter.SplitFileWriter
-	c               config.Config
-	db              *sql.DB
-	sourcesDb       *sql.DB
+	repairedLines  int64
+	doneFile       *os.File
+	skipFile       *os.File
+	errFile        *os.File
+	quarantineFile *os.File
+	outputFile     io.StringWriter
+	c              config.Config
+	db             *sql.DB
+	sourcesDb      *sql.DB
+	dbEngine       engine.Engine
+
+	// import progress, used to resume an interrupted import
+	lastWritten checkpoint.Checkpoint
+	pendingDone []string
+	doneFiles   map[string]bool
+	resumeFrom  checkpoint.Checkpoint
 )
 
+// shared between the signal handler and the command that is running
+var (
+	// signalInterrupt is 1 once a signal has been caught
+	signalInterrupt int32
+	// recoveryNote explains how to recover if dumpdb exits immediately on a second signal
+	recoveryNote   string
+	recoveryNoteMu sync.Mutex
+)
+
+// signalCaught checks if a signal has been caught, so the command should stop gracefully
+func signalCaught() bool {
+	return atomic.LoadInt32(&signalInterrupt) == 1
+}
+
+// setRecoveryNote replaces the recovery note. An empty note means there is nothing to recover
+func setRecoveryNote(note string) {
+	recoveryNoteMu.Lock()
+	recoveryNote = note
+	recoveryNoteMu.Unlock()
+}
+
+// prependRecoveryNote adds a step that must happen before the rest of the recovery note
+func prependRecoveryNote(note string) {
+	recoveryNoteMu.Lock()
+	recoveryNote = note + " " + recoveryNote
+	recoveryNoteMu.Unlock()
+}
+
+func getRecoveryNote() string {
+	recoveryNoteMu.Lock()
+	defer recoveryNoteMu.Unlock()
+	return recoveryNote
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -67,13 +118,19 @@ func init() {
 		return c.Verbosity
 	})
 
-	// listen for CTRL+C
-	signalChannel := make(chan os.Signal, 1)
-	signal.Notify(signalChannel, os.Interrupt)
+	// listen for CTRL+C and SIGTERM
+	signalChannel := make(chan os.Signal, 2)
+	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-signalChannel
-		signalInterrupt = true
-		l.I("CTRL+C caught, stopping gracefully")
+		atomic.StoreInt32(&signalInterrupt, 1)
+		l.I("Signal caught, stopping gracefully. Send another signal to exit immediately")
+
+		<-signalChannel
+		if note := getRecoveryNote(); note != "" {
+			l.W(note)
+		}
+		l.F("Second signal caught, exiting immediately")
 	}()
 }
 
@@ -109,4 +166,18 @@ func initConfig() {
 	} else {
 		l.D("No config file found")
 	}
+
+	loadConfigParsers()
+}
+
+// loadConfigParsers registers the line parsers defined under `parsers` in the config file
+func loadConfigParsers() {
+	parsers := make(map[string]parseline.ParserConfig)
+	err := v.UnmarshalKey("parsers", &parsers)
+	l.FatalOnErr("Parsing line parsers from the config file", err)
+
+	for name, pc := range parsers {
+		l.FatalOnErr("Registering line parser "+name, parseline.Register(name, pc))
+		l.D("Registered line parser " + name + " from the config file")
+	}
 }
@@ -0,0 +1,97 @@
+package checkpoint
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A Checkpoint is the position in the input files that has been loaded into the database
+type Checkpoint struct {
+	// Source is the file that was being processed
+	Source string
+	// Line is the last line of Source that has records loaded
+	Line int64
+	// Records is the number of records of Line that have been loaded, for lines that contain several records
+	Records int64
+	// Batch is the increment of the last tmp file that was loaded
+	Batch int
+}
+
+// Load reads a checkpoint file. It returns false if the file does not exist
+func Load(path string) (Checkpoint, bool, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Checkpoint{}, false, nil
+	}
+	if err != nil {
+		return Checkpoint{}, false, err
+	}
+
+	// source names may contain tabs, so take the numbers from the end
+	parts := strings.Split(strings.TrimRight(string(b), "\n"), "\t")
+	n := len(parts)
+	if n < 4 {
+		return Checkpoint{}, false, errors.New("Invalid checkpoint file " + path)
+	}
+
+	cp := Checkpoint{Source: strings.Join(parts[:n-3], "\t")}
+	cp.Line, err = strconv.ParseInt(parts[n-3], 10, 64)
+	if err != nil {
+		return Checkpoint{}, false, err
+	}
+	cp.Records, err = strconv.ParseInt(parts[n-2], 10, 64)
+	if err != nil {
+		return Checkpoint{}, false, err
+	}
+	cp.Batch, err = strconv.Atoi(parts[n-1])
+	if err != nil {
+		return Checkpoint{}, false, err
+	}
+	return cp, true, nil
+}
+
+// Save atomically replaces the checkpoint file, in the format `source\tline\trecords\tbatch`
+func (cp Checkpoint) Save(path string) error {
+	tmp := path + ".tmp"
+	s := cp.Source + "\t" + strconv.FormatInt(cp.Line, 10) + "\t" + strconv.FormatInt(cp.Records, 10) + "\t" + strconv.Itoa(cp.Batch) + "\n"
+	err := ioutil.WriteFile(tmp, []byte(s), 0664)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Remove deletes the checkpoint file, ignoring it if it does not exist
+func Remove(path string) error {
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// LoadDone reads the set of completed files from a done log, which has one file per line
+func LoadDone(path string) (map[string]bool, error) {
+	done := make(map[string]bool)
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			done[line] = true
+		}
+	}
+	return done, scanner.Err()
+}
@@ -8,12 +8,26 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/darkmattermatt/dumpdb/internal/engine"
 	"github.com/darkmattermatt/dumpdb/internal/parseline"
+	"github.com/darkmattermatt/dumpdb/internal/schema"
+	"github.com/darkmattermatt/dumpdb/internal/sourceid"
+	"github.com/darkmattermatt/dumpdb/pkg/globmatch"
 	"github.com/darkmattermatt/dumpdb/pkg/pathexists"
 	"github.com/darkmattermatt/dumpdb/pkg/simplelog"
 	"github.com/darkmattermatt/dumpdb/pkg/stringinslice"
+	"github.com/darkmattermatt/dumpdb/pkg/transcode"
 )
 
+// StdinPath is the file path that means read from standard input
+const StdinPath = "-"
+
+// A ParserMapping routes files matching a glob to a line parser
+type ParserMapping struct {
+	Glob   string
+	Parser string
+}
+
 // Config contains the configuration options for DumpDB
 type Config struct {
 	// root
@@ -31,13 +45,45 @@ type Config struct {
 	OutputFormat string
 	Columns      []string
 
+	// parser test
+	TestLines int
+	TestShow  int
+
+	// migrate
+	DryRun bool
+
+	// reprocess
+	ErrLogs    []string
+	SkipLogs   []string
+	MatchError *regexp.Regexp
+	FromParser string
+
 	// import
-	FilesOrFolders []string
-	LineParser     string
-	Database       string
-	Compress       bool
-	BatchSize      int
-	FilePrefix     string
+	FilesOrFolders    []string
+	SourceName        sourceid.Template
+	Include           []string
+	Exclude           []string
+	FollowSymlinks    bool
+	MaxDepth          int
+	MaxSize           int64
+	Encoding          string
+	MaxLineLength     int
+	Sniff             bool
+	AllowExtensions   []string
+	DenyExtensions    []string
+	LineParser        string
+	ParserMap         []ParserMapping
+	AutoSample        int
+	AutoThreshold     float64
+	Database          string
+	Compress          bool
+	BatchSize         int
+	FilePrefix        string
+	Resume            bool
+	Remote            bool
+	Loader            string
+	InsertBatchSize   int
+	InsertConcurrency int
 }
 
 // SetVerbosity sets the Config verbosity
@@ -77,6 +123,9 @@ func (c *Config) SetDatabases(dbs []string) error {
 		if dbType != "main" {
 			return errors.New("The specified database is not a DumpDB 'main' database type")
 		}
+		if err := schema.Check(conn); err != nil {
+			return errors.New(db + ": " + err.Error())
+		}
 	}
 
 	c.Databases = dbs
@@ -119,6 +168,9 @@ func (c *Config) SetSourcesDatabase(s string) error {
 	if dbType != "sources" {
 		return errors.New("The specified database is not a DumpDB 'sources' database type")
 	}
+	if err := schema.Check(conn); err != nil {
+		return err
+	}
 
 	c.SourcesDatabase = s
 	return nil
@@ -126,11 +178,11 @@ func (c *Config) SetSourcesDatabase(s string) error {
 
 // SetEngine sets the database storage engine
 func (c *Config) SetEngine(e string) error {
-	supportedEngines := []string{"aria", "myisam"}
+	supportedEngines := engine.Names()
 	if !stringinslice.StringInSlice(strings.ToLower(e), supportedEngines) {
 		return errors.New("Error: unknown database engine: " + e + ". Supported engines are: " + strings.Join(supportedEngines, ", "))
 	}
-	c.Engine = e
+	c.Engine = strings.ToLower(e)
 	return nil
 }
 
@@ -199,28 +251,204 @@ func (c *Config) SetColumns(cols []string) error {
 	return nil
 }
 
+// SetTestLines sets the number of lines of each file to parse when testing a line parser, 0 parses every line
+func (c *Config) SetTestLines(n int) error {
+	if n < 0 {
+		return fmt.Errorf("Invalid number of lines: is %d, must be greater than or equal to 0", n)
+	}
+	c.TestLines = n
+	return nil
+}
+
+// SetTestShow sets the number of parsed lines of each file to print when testing a line parser
+func (c *Config) SetTestShow(n int) error {
+	if n < 0 {
+		return fmt.Errorf("Invalid number of lines to show: is %d, must be greater than or equal to 0", n)
+	}
+	c.TestShow = n
+	return nil
+}
+
 // SetFilesOrFolders sets the files or folders to import
 func (c *Config) SetFilesOrFolders(paths []string) error {
 	if len(paths) < 1 {
 		return errors.New("Missing files or folders to import")
 	}
-	err := pathexists.AssertPathsAllExist(paths)
+
+	stdinCount := 0
+	for _, path := range paths {
+		if path == StdinPath {
+			stdinCount++
+			continue
+		}
+		if err := pathexists.AssertPathExists(path); err != nil {
+			return err
+		}
+	}
+	if stdinCount > 1 {
+		return errors.New("Standard input (" + StdinPath + ") can only be read once")
+	}
+
+	c.FilesOrFolders = paths
+	return nil
+}
+
+// SetSourceName sets the template of the source name of each record, e.g. {archive}/{member}
+func (c *Config) SetSourceName(template string) error {
+	t, err := sourceid.ParseTemplate(template)
 	if err != nil {
 		return err
 	}
-	c.FilesOrFolders = paths
+	c.SourceName = t
+	return nil
+}
+
+// SetInclude sets the globs that files inside folders must match to be processed
+func (c *Config) SetInclude(globs []string) error {
+	for _, g := range globs {
+		if err := globmatch.Validate(g); err != nil {
+			return errors.New("Invalid include glob '" + g + "': " + err.Error())
+		}
+	}
+	c.Include = globs
+	return nil
+}
+
+// SetExclude sets the globs of files and folders to skip inside folders
+func (c *Config) SetExclude(globs []string) error {
+	for _, g := range globs {
+		if err := globmatch.Validate(g); err != nil {
+			return errors.New("Invalid exclude glob '" + g + "': " + err.Error())
+		}
+	}
+	c.Exclude = globs
+	return nil
+}
+
+// SetFollowSymlinks sets whether symbolic links inside folders are followed
+func (c *Config) SetFollowSymlinks(follow bool) error {
+	c.FollowSymlinks = follow
+	return nil
+}
+
+// SetMaxDepth sets the maximum number of nested archives to open
+func (c *Config) SetMaxDepth(depth int) error {
+	if depth < 0 {
+		return fmt.Errorf("Invalid maximum archive depth: is %d, must be greater than or equal to 0", depth)
+	}
+	c.MaxDepth = depth
+	return nil
+}
+
+// SetMaxSize sets the maximum number of bytes to decompress from a single file
+func (c *Config) SetMaxSize(size int64) error {
+	if size < 0 {
+		return fmt.Errorf("Invalid maximum decompressed size: is %d, must be greater than or equal to 0", size)
+	}
+	c.MaxSize = size
+	return nil
+}
+
+// SetEncoding sets the character encoding of the text files, or "auto" to detect it
+func (c *Config) SetEncoding(e string) error {
+	if _, err := transcode.Lookup(e); err != nil {
+		return err
+	}
+	c.Encoding = strings.ToLower(e)
+	return nil
+}
+
+// SetMaxLineLength sets the maximum number of bytes in a line, longer lines are quarantined
+func (c *Config) SetMaxLineLength(length int) error {
+	if length < 1 {
+		return fmt.Errorf("Invalid maximum line length: is %d, must be greater than 0", length)
+	}
+	c.MaxLineLength = length
+	return nil
+}
+
+// SetSniff sets whether to skip text files that look like binary files
+func (c *Config) SetSniff(sniff bool) error {
+	c.Sniff = sniff
+	return nil
+}
+
+// normaliseExtensions lowercases file extensions and adds the leading dot
+func normaliseExtensions(exts []string) []string {
+	normalised := make([]string, len(exts))
+	for i, ext := range exts {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		normalised[i] = ext
+	}
+	return normalised
+}
+
+// SetAllowExtensions sets the file extensions of text files to process. Empty allows any extension
+func (c *Config) SetAllowExtensions(exts []string) error {
+	c.AllowExtensions = normaliseExtensions(exts)
+	return nil
+}
+
+// SetDenyExtensions sets the file extensions of text files to skip
+func (c *Config) SetDenyExtensions(exts []string) error {
+	c.DenyExtensions = normaliseExtensions(exts)
 	return nil
 }
 
 // SetLineParser sets the function to parse lines when importing
 func (c *Config) SetLineParser(p string) error {
-	if !parseline.ParserExists(p) {
-		return errors.New("Error: unknown line parser: " + p + ". Have you made a new parser for your dump in the internal/parseline package?")
+	// line parser names are case-insensitive
+	p = strings.ToLower(p)
+	if p != parseline.Auto && !parseline.ParserExists(p) {
+		return errors.New("Error: unknown line parser: " + p + ". Have you defined a parser for your dump in the config file or the internal/parseline package?")
 	}
 	c.LineParser = p
 	return nil
 }
 
+// SetParserMap sets the line parsers of files matching globs, from a list of `glob=parser`
+func (c *Config) SetParserMap(mappings []string) error {
+	parserMap := make([]ParserMapping, len(mappings))
+	for i, m := range mappings {
+		idx := strings.LastIndex(m, "=")
+		if idx < 0 {
+			return errors.New("Invalid parser mapping '" + m + "': must be in the format `glob=parser`")
+		}
+
+		glob, p := strings.TrimSpace(m[:idx]), strings.ToLower(strings.TrimSpace(m[idx+1:]))
+		if err := globmatch.Validate(glob); err != nil {
+			return errors.New("Invalid parser mapping glob '" + glob + "': " + err.Error())
+		}
+		if p != parseline.Auto && !parseline.ParserExists(p) {
+			return errors.New("Error: unknown line parser in parser mapping: " + p)
+		}
+		parserMap[i] = ParserMapping{Glob: glob, Parser: p}
+	}
+	c.ParserMap = parserMap
+	return nil
+}
+
+// SetAutoSample sets the number of lines of each file to sample when detecting the line parser
+func (c *Config) SetAutoSample(n int) error {
+	if n < 1 {
+		return fmt.Errorf("Invalid auto parser sample size: is %d, must be greater than 0", n)
+	}
+	c.AutoSample = n
+	return nil
+}
+
+// SetAutoThreshold sets the minimum score of a detected line parser, files below it are skipped
+func (c *Config) SetAutoThreshold(t float64) error {
+	if t < 0 || t > 1 {
+		return fmt.Errorf("Invalid auto parser threshold: is %g, must be between 0 and 1", t)
+	}
+	c.AutoThreshold = t
+	return nil
+}
+
 // SetDatabase sets the 'main' database name, SetConn must be called first
 func (c *Config) SetDatabase(s string) error {
 	if c.Conn == "" {
@@ -241,6 +469,9 @@ func (c *Config) SetDatabase(s string) error {
 	if dbType != "main" {
 		return errors.New("The specified database is not a DumpDB 'main' database type")
 	}
+	if err := schema.Check(conn); err != nil {
+		return err
+	}
 
 	c.Database = s
 	return nil
@@ -276,3 +507,94 @@ func (c *Config) SetFilePrefix(prefix string) error {
 	c.FilePrefix = prefix
 	return nil
 }
+
+// SetResume sets whether to skip files in the done log and continue from the last checkpoint
+func (c *Config) SetResume(resume bool) error {
+	c.Resume = resume
+	return nil
+}
+
+// SetRemote sets whether to send batches to the database server instead of writing tmp files that the server reads
+func (c *Config) SetRemote(remote bool) error {
+	c.Remote = remote
+	return nil
+}
+
+// Loaders are the ways of loading batches into the database. auto uses LOAD DATA, and INSERT statements if the server
+// doesn't allow LOAD DATA
+var Loaders = []string{"auto", "insert"}
+
+// SetLoader sets how batches are loaded into the database
+func (c *Config) SetLoader(loader string) error {
+	if !stringinslice.StringInSlice(strings.ToLower(loader), Loaders) {
+		return errors.New("Error: unknown loader: " + loader + ". Supported loaders are: " + strings.Join(Loaders, ", "))
+	}
+	c.Loader = strings.ToLower(loader)
+	return nil
+}
+
+// SetInsertBatchSize sets the number of rows of each INSERT statement, when batches are loaded with INSERT statements
+func (c *Config) SetInsertBatchSize(size int) error {
+	if size < 1 || size > engine.MaxInsertRows {
+		return fmt.Errorf("Invalid insert batch size: is %d, must be between 1 and %d", size, engine.MaxInsertRows)
+	}
+	c.InsertBatchSize = size
+	return nil
+}
+
+// SetInsertConcurrency sets the number of connections that send INSERT statements at once
+func (c *Config) SetInsertConcurrency(n int) error {
+	if n < 1 {
+		return fmt.Errorf("Invalid insert concurrency: is %d, must be at least 1", n)
+	}
+	c.InsertConcurrency = n
+	return nil
+}
+
+// SetErrLogs sets the error logs whose lines are reprocessed
+func (c *Config) SetErrLogs(paths []string) error {
+	for _, path := range paths {
+		if err := pathexists.AssertPathExists(path); err != nil {
+			return err
+		}
+	}
+	c.ErrLogs = paths
+	return nil
+}
+
+// SetSkipLogs sets the skip logs whose files are reprocessed
+func (c *Config) SetSkipLogs(paths []string) error {
+	for _, path := range paths {
+		if err := pathexists.AssertPathExists(path); err != nil {
+			return err
+		}
+	}
+	c.SkipLogs = paths
+	return nil
+}
+
+// SetMatchError sets the regex that the error or skip reason of a line or file must match to be reprocessed
+func (c *Config) SetMatchError(pattern string) error {
+	if pattern == "" {
+		c.MatchError = nil
+		return nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	c.MatchError = re
+	return nil
+}
+
+// SetFromParser sets the line parser that lines must have failed with to be reprocessed. Empty means any line parser
+func (c *Config) SetFromParser(name string) error {
+	c.FromParser = name
+	return nil
+}
+
+// SetDryRun sets whether to only print the migrations that would run
+func (c *Config) SetDryRun(dryRun bool) error {
+	c.DryRun = dryRun
+	return nil
+}
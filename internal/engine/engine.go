@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// metadataTable is the table of each DumpDB database that stores key/value metadata
+const metadataTable = "metadata"
+
+// CommandCallback is called with the output of each external tool that an engine runs
+var CommandCallback func(name, output string)
+
+// Options are the table that an engine works on and how it does so
+type Options struct {
+	DB       *sql.DB
+	Database string
+	Table    string
+	// Remote is true if the database server's files can't be accessed, so the table is only changed through SQL
+	Remote bool
+	// Compression is whether Compress will be called, so that Check can look for the tools it needs
+	Compression bool
+	// TmpDir is the folder for temporary files while rebuilding indexes and compressing
+	TmpDir string
+	// SortBuffer is the number of bytes of memory to use for sorting while rebuilding indexes
+	SortBuffer uint64
+	// InsertRows is the number of rows of each INSERT statement of Insert
+	InsertRows int
+	// InsertConcurrency is the number of connections that Insert uses at once
+	InsertConcurrency int
+}
+
+// An Engine prepares a table for bulk loading, loads batches into it and rebuilds its indexes afterwards.
+// The methods are called in the order: Check, DisableIndexes, Load, LoadReader or Insert (many times),
+// Compress (optional), RebuildIndexes
+type Engine interface {
+	// Check verifies that the table can be bulk loaded, e.g. that the required tools are installed
+	Check() error
+	// DisableIndexes stops the indexes from being updated while loading
+	DisableIndexes() error
+	// Load loads a batch file of tab-delimited records, which the database server can read, into the table
+	Load(path string) error
+	// LoadReader loads a batch of tab-delimited records into the table, sending them from dumpdb
+	LoadReader(r io.Reader) error
+	// Insert loads a batch of tab-delimited records into the table with INSERT statements, for servers that don't allow
+	// LOAD DATA
+	Insert(r io.Reader) error
+	// Compress packs the table into a compressed format
+	Compress() error
+	// RebuildIndexes enables and rebuilds the indexes after loading
+	RebuildIndexes() error
+	// RecoveryNote explains how to restore the indexes if dumpdb exits between DisableIndexes and RebuildIndexes
+	RecoveryNote() string
+	// NeedsRestart is true if the database server must be restarted before it uses the rebuilt indexes
+	NeedsRestart() bool
+}
+
+// engines maps the name of each storage engine (lowercase, as in information_schema) to its constructor
+var engines = map[string]func(o Options) Engine{
+	"aria":   newAria,
+	"myisam": newMyISAM,
+	"innodb": newInnoDB,
+}
+
+// Names returns the names of the supported storage engines, in alphabetical order
+func Names() []string {
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the engine for the storage engine of a table
+func New(name string, o Options) (Engine, error) {
+	newEngine, ok := engines[strings.ToLower(name)]
+	if !ok {
+		return nil, errors.New("unknown database engine: " + name + ". Supported engines are: " + strings.Join(Names(), ", "))
+	}
+	return newEngine(o), nil
+}
+
+// table implements the parts of an engine that are the same for every storage engine
+type table struct {
+	Options
+}
+
+// Load uses LOAD DATA INFILE, so the batch file must be readable by the database server
+func (t *table) Load(path string) error {
+	path = strings.ReplaceAll(path, "\\", "\\\\")
+	return t.load("INFILE '" + path + "'")
+}
+
+// readerCount numbers the reader handlers that are registered with the mysql driver
+var readerCount int64
+
+// LoadReader uses LOAD DATA LOCAL INFILE, which sends the batch from dumpdb, so the server's local_infile must be ON
+func (t *table) LoadReader(r io.Reader) error {
+	name := "dumpdb_" + strconv.FormatInt(atomic.AddInt64(&readerCount, 1), 10)
+	mysql.RegisterReaderHandler(name, func() io.Reader {
+		return r
+	})
+	defer mysql.DeregisterReaderHandler(name)
+	return t.load("LOCAL INFILE 'Reader::" + name + "'")
+}
+
+// load loads tab-delimited records from `infile`, which is the source part of a LOAD DATA statement
+func (t *table) load(infile string) error {
+	_, err := t.DB.Exec(`
+		LOAD DATA ` + infile + `
+		IGNORE INTO TABLE ` + t.Table + `
+		FIELDS TERMINATED BY '\t' ESCAPED BY ''
+		LINES TERMINATED BY '\n'
+		(sourceid, username, email_rev, hash, password, extra)
+	`)
+	return err
+}
+
+// quoteIdentifier quotes a table, column or index name for SQL
+func quoteIdentifier(s string) string {
+	return "`" + strings.ReplaceAll(s, "`", "``") + "`"
+}
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestNew(t *testing.T) {
+	if got, want := Names(), []string{"aria", "innodb", "myisam"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Names() = %v, want %v", got, want)
+	}
+
+	for _, name := range []string{"aria", "MyISAM", "InnoDB"} {
+		if _, err := New(name, Options{Table: "main"}); err != nil {
+			t.Errorf("New(%q) failed: %v", name, err)
+		}
+	}
+	if _, err := New("memory", Options{}); err == nil {
+		t.Error("expected an error for an unsupported engine")
+	}
+}
+
+func TestIndexDefinition(t *testing.T) {
+	tests := []struct {
+		name, indexType string
+		columns         []string
+		want            string
+	}{
+		{"idx_email_rev", "BTREE", []string{"`email_rev`"}, "INDEX `idx_email_rev` (`email_rev`)"},
+		{"idx_pair", "BTREE", []string{"`username`", "`hash`(16)"}, "INDEX `idx_pair` (`username`, `hash`(16))"},
+		{"ft", "FULLTEXT", []string{"`extra`"}, "FULLTEXT INDEX `ft` (`extra`)"},
+		{"odd`name", "BTREE", []string{"`password`"}, "INDEX `odd``name` (`password`)"},
+	}
+	for _, tt := range tests {
+		if got := indexDefinition(tt.name, tt.indexType, tt.columns); got != tt.want {
+			t.Errorf("indexDefinition(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInnoDBRecoveryNote(t *testing.T) {
+	e := &innodbEngine{table: table{Options{Table: "main"}}}
+	if note := e.RecoveryNote(); note != "" {
+		t.Errorf("expected no recovery note before any indexes are dropped, got %q", note)
+	}
+
+	e.dropped = []string{"INDEX `idx_email_rev` (`email_rev`)", "INDEX `idx_username` (`username`)"}
+	want := "ALTER TABLE `main` ADD INDEX `idx_email_rev` (`email_rev`), ADD INDEX `idx_username` (`username`)"
+	if got := e.addIndexesStatement(); got != want {
+		t.Errorf("addIndexesStatement() = %s, want %s", got, want)
+	}
+}
+
+func TestInsertStatement(t *testing.T) {
+	tb := &table{Options{Table: "main"}}
+	want := "INSERT IGNORE INTO `main` (sourceid, username, email_rev, hash, password, extra) VALUES (?, ?, ?, ?, ?, ?), (?, ?, ?, ?, ?, ?)"
+	if got := tb.insertStatement(2); got != want {
+		t.Errorf("insertStatement(2) = %s, want %s", got, want)
+	}
+}
+
+func TestReadInsertChunks(t *testing.T) {
+	records := "1\tuser\tmoc.elpmaxe@a\t\tpass\t\n2\t\t\thash\t\textra\n3\tlast\t\t\t\t"
+	chunks := make(chan insertChunk, 10)
+	if err := readInsertChunks(strings.NewReader(records), 2, chunks, make(chan struct{})); err != nil {
+		t.Fatal(err)
+	}
+	close(chunks)
+
+	var rows []int
+	var values []interface{}
+	for chunk := range chunks {
+		rows = append(rows, chunk.rows)
+		values = append(values, chunk.values...)
+	}
+	if want := []int{2, 1}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("chunk sizes = %v, want %v", rows, want)
+	}
+	if len(values) != 18 || values[2] != "moc.elpmaxe@a" || values[11] != "extra" || values[13] != "last" {
+		t.Errorf("unexpected values %q", values)
+	}
+
+	err := readInsertChunks(strings.NewReader("1\tonly\tthree\n"), 2, make(chan insertChunk, 1), make(chan struct{}))
+	if err == nil {
+		t.Error("expected an error for a record with missing fields")
+	}
+}
+
+func TestIsLoadDataDenied(t *testing.T) {
+	if !IsLoadDataDenied(&mysql.MySQLError{Number: 1290, Message: "--secure-file-priv"}) {
+		t.Error("expected secure_file_priv errors to deny LOAD DATA")
+	}
+	if IsLoadDataDenied(&mysql.MySQLError{Number: 1062}) || IsLoadDataDenied(errors.New("connection refused")) {
+		t.Error("expected other errors not to deny LOAD DATA")
+	}
+}
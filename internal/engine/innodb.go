@@ -0,0 +1,207 @@
+package engine
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// droppedIndexesKey is the metadata key that stores the definitions of the indexes that were dropped while loading,
+// one per line, so that they can be recreated after an interrupted import
+const droppedIndexesKey = "dropped_indexes"
+
+// innodbEngine only uses SQL statements, so it also works with servers whose files dumpdb can't access.
+// InnoDB ignores DISABLE KEYS, so the secondary indexes are dropped while loading and recreated afterwards
+type innodbEngine struct {
+	table
+	// dropped are the definitions of the indexes that were dropped
+	dropped []string
+}
+
+func newInnoDB(o Options) Engine {
+	return &innodbEngine{table: table{o}}
+}
+
+// Check checks that page compression is possible if the table will be compressed
+func (e *innodbEngine) Check() error {
+	if !e.Compression {
+		return nil
+	}
+
+	var filePerTable string
+	if err := e.DB.QueryRow("SELECT @@innodb_file_per_table").Scan(&filePerTable); err != nil {
+		return err
+	}
+	if filePerTable != "1" && !strings.EqualFold(filePerTable, "on") {
+		return errors.New("InnoDB page compression requires innodb_file_per_table")
+	}
+	return nil
+}
+
+// indexDefinition builds the definition of an index for ALTER TABLE ... ADD
+func indexDefinition(name, indexType string, columns []string) string {
+	prefix := "INDEX "
+	switch strings.ToUpper(indexType) {
+	case "FULLTEXT":
+		prefix = "FULLTEXT INDEX "
+	case "SPATIAL":
+		prefix = "SPATIAL INDEX "
+	}
+	return prefix + quoteIdentifier(name) + " (" + strings.Join(columns, ", ") + ")"
+}
+
+// secondaryIndexes returns the names and definitions of the non-unique indexes of the table. Unique indexes are kept,
+// because they decide which records are duplicates
+func (e *innodbEngine) secondaryIndexes() (names, defs []string, err error) {
+	rows, err := e.DB.Query(`
+		SELECT index_name, index_type, column_name, sub_part
+		FROM information_schema.statistics
+		WHERE table_schema = ? AND table_name = ? AND non_unique = 1
+		ORDER BY index_name, seq_in_index
+	`, e.Database, e.Table)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var indexType string
+	var columns []string
+	for rows.Next() {
+		var name, typ, column string
+		var subPart sql.NullInt64
+		if err := rows.Scan(&name, &typ, &column, &subPart); err != nil {
+			return nil, nil, err
+		}
+
+		if len(names) == 0 || names[len(names)-1] != name {
+			if len(names) > 0 {
+				defs = append(defs, indexDefinition(names[len(names)-1], indexType, columns))
+			}
+			names, indexType, columns = append(names, name), typ, nil
+		}
+		column = quoteIdentifier(column)
+		if subPart.Valid {
+			column += "(" + strconv.FormatInt(subPart.Int64, 10) + ")"
+		}
+		columns = append(columns, column)
+	}
+	if len(names) > 0 {
+		defs = append(defs, indexDefinition(names[len(names)-1], indexType, columns))
+	}
+	return names, defs, rows.Err()
+}
+
+// savedIndexes returns the definitions of the indexes that an interrupted import dropped
+func (e *innodbEngine) savedIndexes() ([]string, error) {
+	var saved string
+	err := e.DB.QueryRow("SELECT v FROM "+metadataTable+" WHERE k = ?", droppedIndexesKey).Scan(&saved)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil || saved == "" {
+		return nil, err
+	}
+	return strings.Split(saved, "\n"), nil
+}
+
+// DisableIndexes saves the definitions of the secondary indexes in the metadata table, then drops them
+func (e *innodbEngine) DisableIndexes() error {
+	saved, err := e.savedIndexes()
+	if err != nil {
+		return err
+	}
+	names, defs, err := e.secondaryIndexes()
+	if err != nil {
+		return err
+	}
+
+	e.dropped = saved
+	for _, def := range defs {
+		known := false
+		for _, s := range saved {
+			known = known || s == def
+		}
+		if !known {
+			e.dropped = append(e.dropped, def)
+		}
+	}
+
+	if len(e.dropped) > 0 {
+		_, err = e.DB.Exec(`
+			INSERT INTO `+metadataTable+` (k, v)
+			VALUES (?, ?)
+			ON DUPLICATE KEY UPDATE v=VALUES(v)
+		`, droppedIndexesKey, strings.Join(e.dropped, "\n"))
+		if err != nil {
+			return err
+		}
+	}
+	if len(names) > 0 {
+		drops := make([]string, len(names))
+		for i, name := range names {
+			drops[i] = "DROP INDEX " + quoteIdentifier(name)
+		}
+		if _, err := e.DB.Exec("ALTER TABLE " + quoteIdentifier(e.Table) + " " + strings.Join(drops, ", ")); err != nil {
+			return err
+		}
+	}
+
+	_, err = e.DB.Exec("ALTER TABLE " + quoteIdentifier(e.Table) + " DISABLE KEYS")
+	return err
+}
+
+// addIndexesStatement recreates the dropped indexes
+func (e *innodbEngine) addIndexesStatement() string {
+	adds := make([]string, len(e.dropped))
+	for i, def := range e.dropped {
+		adds[i] = "ADD " + def
+	}
+	return "ALTER TABLE " + quoteIdentifier(e.Table) + " " + strings.Join(adds, ", ")
+}
+
+// RebuildIndexes recreates the dropped indexes in a single pass over the table
+func (e *innodbEngine) RebuildIndexes() error {
+	if _, err := e.DB.Exec("ALTER TABLE " + quoteIdentifier(e.Table) + " ENABLE KEYS"); err != nil {
+		return err
+	}
+	if len(e.dropped) == 0 {
+		return nil
+	}
+
+	if _, err := e.DB.Exec(e.addIndexesStatement()); err != nil {
+		return err
+	}
+	_, err := e.DB.Exec("DELETE FROM "+metadataTable+" WHERE k = ?", droppedIndexesKey)
+	return err
+}
+
+// Compress enables page compression, which keeps the table writable. MariaDB and MySQL use different table options
+func (e *innodbEngine) Compress() error {
+	var version string
+	if err := e.DB.QueryRow("SELECT VERSION()").Scan(&version); err != nil {
+		return err
+	}
+
+	if strings.Contains(strings.ToLower(version), "mariadb") {
+		_, err := e.DB.Exec("ALTER TABLE " + quoteIdentifier(e.Table) + " PAGE_COMPRESSED=1")
+		return err
+	}
+
+	// MySQL only compresses pages as they are written, so rebuild the table to compress the existing pages
+	if _, err := e.DB.Exec("ALTER TABLE " + quoteIdentifier(e.Table) + " COMPRESSION='zlib'"); err != nil {
+		return err
+	}
+	_, err := e.DB.Exec("OPTIMIZE TABLE " + quoteIdentifier(e.Table))
+	return err
+}
+
+func (e *innodbEngine) RecoveryNote() string {
+	if len(e.dropped) == 0 {
+		return ""
+	}
+	return "The secondary indexes were dropped, and are saved under " + droppedIndexesKey + " in the metadata table. To restore them, finish the import or run `" + e.addIndexesStatement() + "`."
+}
+
+func (e *innodbEngine) NeedsRestart() bool {
+	return false
+}
@@ -0,0 +1,186 @@
+package engine
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// insertColumns are the columns of each tab-delimited record, in the order LOAD DATA loads them
+var insertColumns = []string{"sourceid", "username", "email_rev", "hash", "password", "extra"}
+
+// MaxInsertRows is the most rows that one INSERT statement can have, because a prepared statement can't have more than
+// 65535 placeholders
+const MaxInsertRows = 65535 / 6
+
+// insertsPerTransaction is the number of INSERT statements that are committed together
+const insertsPerTransaction = 16
+
+// insertChunk is the values of the rows of one INSERT statement
+type insertChunk struct {
+	rows   int
+	values []interface{}
+}
+
+// Insert loads a batch of tab-delimited records with multi-row INSERT IGNORE statements, for servers that don't allow
+// LOAD DATA. InsertConcurrency connections insert at once, each in transactions of several statements of InsertRows rows
+func (t *table) Insert(r io.Reader) error {
+	rows := t.InsertRows
+	if rows < 1 || rows > MaxInsertRows {
+		rows = 1000
+	}
+	workers := t.InsertConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	chunks := make(chan insertChunk, workers)
+	failed := make(chan struct{})
+	var failOnce sync.Once
+	var firstErr error
+	fail := func(err error) {
+		failOnce.Do(func() {
+			firstErr = err
+			close(failed)
+		})
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := t.insertWorker(rows, chunks); err != nil {
+				fail(err)
+			}
+		}()
+	}
+
+	err := readInsertChunks(r, rows, chunks, failed)
+	close(chunks)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return err
+}
+
+// readInsertChunks splits the records of `r` into chunks of `rows` rows, until the records end or a worker fails
+func readInsertChunks(r io.Reader, rows int, chunks chan<- insertChunk, failed <-chan struct{}) error {
+	br := bufio.NewReader(r)
+	chunk := insertChunk{values: make([]interface{}, 0, rows*len(insertColumns))}
+	send := func() bool {
+		select {
+		case chunks <- chunk:
+		case <-failed:
+			return false
+		}
+		chunk = insertChunk{values: make([]interface{}, 0, rows*len(insertColumns))}
+		return true
+	}
+
+	for lineNum := 1; ; lineNum++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line = strings.TrimSuffix(line, "\n"); line != "" {
+			fields := strings.Split(line, "\t")
+			if len(fields) != len(insertColumns) {
+				return errors.New("record " + strconv.Itoa(lineNum) + " has " + strconv.Itoa(len(fields)) + " fields, expected " + strconv.Itoa(len(insertColumns)))
+			}
+			for _, field := range fields {
+				chunk.values = append(chunk.values, field)
+			}
+			chunk.rows++
+			if chunk.rows == rows && !send() {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if chunk.rows > 0 {
+		send()
+	}
+	return nil
+}
+
+// insertWorker inserts chunks in transactions of up to insertsPerTransaction statements. Full chunks use a prepared
+// statement, and the final, partial chunk of the batch uses its own statement
+func (t *table) insertWorker(rows int, chunks <-chan insertChunk) error {
+	var tx *sql.Tx
+	var full *sql.Stmt
+	inserts := 0
+	commit := func() error {
+		err := tx.Commit()
+		tx, full, inserts = nil, nil, 0
+		return err
+	}
+
+	for chunk := range chunks {
+		var err error
+		if tx == nil {
+			if tx, err = t.DB.Begin(); err != nil {
+				return err
+			}
+		}
+		if chunk.rows == rows {
+			if full == nil {
+				if full, err = tx.Prepare(t.insertStatement(rows)); err != nil {
+					tx.Rollback()
+					return err
+				}
+			}
+			_, err = full.Exec(chunk.values...)
+		} else {
+			_, err = tx.Exec(t.insertStatement(chunk.rows), chunk.values...)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if inserts++; inserts == insertsPerTransaction {
+			if err = commit(); err != nil {
+				return err
+			}
+		}
+	}
+	if tx != nil {
+		return commit()
+	}
+	return nil
+}
+
+// insertStatement is an INSERT IGNORE statement with placeholders for `rows` rows. IGNORE skips duplicates and
+// truncates long values like LOAD DATA does
+func (t *table) insertStatement(rows int) string {
+	row := "(?" + strings.Repeat(", ?", len(insertColumns)-1) + ")"
+	return "INSERT IGNORE INTO " + quoteIdentifier(t.Table) + " (" + strings.Join(insertColumns, ", ") + ") VALUES " +
+		row + strings.Repeat(", "+row, rows-1)
+}
+
+// loadDataDenied are the MySQL error numbers of a server refusing LOAD DATA, or not being able to read the file
+var loadDataDenied = map[uint16]bool{
+	13:   true, // EE_STAT: the server can't find the file, e.g. it is on another host
+	29:   true, // ER_FILE_NOT_FOUND
+	1045: true, // ER_ACCESS_DENIED_ERROR: no FILE privilege
+	1148: true, // ER_NOT_ALLOWED_COMMAND: local_infile is OFF
+	1227: true, // ER_SPECIFIC_ACCESS_DENIED_ERROR
+	1290: true, // ER_OPTION_PREVENTS_STATEMENT: secure_file_priv
+	3948: true, // ER_CLIENT_LOCAL_FILES_DISABLED
+}
+
+// IsLoadDataDenied is true if a Load or LoadReader error means that the server doesn't allow LOAD DATA, so Insert must
+// be used instead
+func IsLoadDataDenied(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && loadDataDenied[mysqlErr.Number]
+}
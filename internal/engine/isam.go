@@ -0,0 +1,193 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// isamEngine rebuilds and compresses Aria and MyISAM tables by running the server's tools on the table files, so dumpdb
+// must run on the database server as a user that can write to the files (usually the mysql user)
+type isamEngine struct {
+	table
+	chk, pack   string
+	bufferParam string
+	// extensions of the data and index files
+	dataExt, indexExt string
+
+	// path is the path of the table files without their extension
+	path string
+	// locked is the connection that holds the table lock while the table files are changed
+	locked *sql.Conn
+}
+
+func newAria(o Options) Engine {
+	if o.Remote {
+		return &keysEngine{table: table{o}, pack: "aria_pack"}
+	}
+	return &isamEngine{
+		table:       table{o},
+		chk:         "aria_chk",
+		pack:        "aria_pack",
+		bufferParam: "--sort_buffer_size",
+		dataExt:     ".MAD",
+		indexExt:    ".MAI",
+	}
+}
+
+func newMyISAM(o Options) Engine {
+	if o.Remote {
+		return &keysEngine{table: table{o}, pack: "myisampack"}
+	}
+	return &isamEngine{
+		table:       table{o},
+		chk:         "myisamchk",
+		pack:        "myisampack",
+		bufferParam: "--myisam_sort_buffer_size",
+		dataExt:     ".MYD",
+		indexExt:    ".MYI",
+	}
+}
+
+// Check finds the table files, and checks that they are writable and that the tools are in PATH
+func (e *isamEngine) Check() error {
+	var dataDir string
+	if err := e.DB.QueryRow("SELECT @@datadir").Scan(&dataDir); err != nil {
+		return errors.New("Querying location of MySQL databases: " + err.Error())
+	}
+	e.path = dataDir + e.Database + "/" + e.Table
+
+	tools := []string{e.chk}
+	if e.Compression {
+		tools = append(tools, e.pack)
+	}
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			return errors.New("Checking that the required database tools are in PATH: " + err.Error())
+		}
+	}
+
+	for _, ext := range []string{e.dataExt, e.indexExt} {
+		f, err := os.OpenFile(e.path+ext, os.O_RDWR, 0)
+		if err != nil {
+			return errors.New("Checking read/write permissions: " + err.Error())
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// run runs one of the tools, returning its output with the error if it fails
+func (e *isamEngine) run(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if CommandCallback != nil {
+		CommandCallback(name, string(out))
+	}
+	if err != nil {
+		return errors.New(name + ": " + err.Error() + ": " + strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (e *isamEngine) DisableIndexes() error {
+	return e.run(e.chk, "-rq", "--keys-used", "0", e.path)
+}
+
+// lock flushes the table to disk and stops the server from using it, so that the tools can change the table files.
+// The lock is held until the indexes are rebuilt, because a compressed table needs its indexes rebuilt before it is used
+func (e *isamEngine) lock() error {
+	if e.locked != nil {
+		return nil
+	}
+
+	conn, err := e.DB.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(context.Background(), "FLUSH TABLES "+quoteIdentifier(e.Table)+" FOR EXPORT")
+	if err != nil {
+		conn.Close()
+		return errors.New("Flushing and locking the `" + e.Table + "` table: " + err.Error())
+	}
+	e.locked = conn
+	return nil
+}
+
+func (e *isamEngine) unlock() error {
+	_, err := e.locked.ExecContext(context.Background(), "UNLOCK TABLES")
+	e.locked.Close()
+	e.locked = nil
+	if err != nil {
+		return errors.New("Unlocking the `" + e.Table + "` table: " + err.Error())
+	}
+	return nil
+}
+
+// Compress packs the table with aria_pack or myisampack. The table is read-only afterwards
+func (e *isamEngine) Compress() error {
+	if err := e.lock(); err != nil {
+		return err
+	}
+	return e.run(e.pack, "--tmpdir", e.TmpDir, e.path)
+}
+
+func (e *isamEngine) RebuildIndexes() error {
+	if err := e.lock(); err != nil {
+		return err
+	}
+	if err := e.run(e.chk, "-rq", e.bufferParam, strconv.FormatUint(e.SortBuffer, 10), "--tmpdir", e.TmpDir, e.path); err != nil {
+		// leave the table locked, the server must not use the table until its indexes are rebuilt
+		return err
+	}
+	return e.unlock()
+}
+
+func (e *isamEngine) RecoveryNote() string {
+	return "The database indexes are disabled. To restore them, run `" + e.chk + " -rq " + e.path + "` as the mysql user and restart the MySQL server."
+}
+
+func (e *isamEngine) NeedsRestart() bool {
+	return true
+}
+
+// keysEngine disables the indexes of Aria and MyISAM tables with ALTER TABLE ... DISABLE KEYS, for servers whose table
+// files can't be accessed. The server rebuilds the indexes by sorting when they are enabled, using its own sort buffer
+type keysEngine struct {
+	table
+	// pack is the tool that compresses the table
+	pack string
+}
+
+// Check refuses to compress, because aria_pack and myisampack need the table files
+func (e *keysEngine) Check() error {
+	if e.Compression {
+		return errors.New("compressing Aria and MyISAM tables needs " + e.pack + " and access to the table files, which remote servers don't allow")
+	}
+	return nil
+}
+
+func (e *keysEngine) DisableIndexes() error {
+	_, err := e.DB.Exec("ALTER TABLE " + quoteIdentifier(e.Table) + " DISABLE KEYS")
+	return err
+}
+
+func (e *keysEngine) RebuildIndexes() error {
+	_, err := e.DB.Exec("ALTER TABLE " + quoteIdentifier(e.Table) + " ENABLE KEYS")
+	return err
+}
+
+func (e *keysEngine) Compress() error {
+	return e.Check()
+}
+
+func (e *keysEngine) RecoveryNote() string {
+	return "The database indexes are disabled. To restore them, run `ALTER TABLE " + quoteIdentifier(e.Table) + " ENABLE KEYS`."
+}
+
+func (e *keysEngine) NeedsRestart() bool {
+	return false
+}
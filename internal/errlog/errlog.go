@@ -0,0 +1,109 @@
+package errlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxEntryLength is the maximum length of a line of the error log. Lines are limited by linescanner.MaxLineLength,
+// but records of stream parsers can span several lines
+const maxEntryLength = 256 * 1024 * 1024
+
+// An Entry is a line, or the lines of a record, that a line parser could not parse
+type Entry struct {
+	// Source is the path of the file, including any archives it is in
+	Source string `json:"source"`
+	// Line is the line number of the (last) line, starting at 1
+	Line int64 `json:"line"`
+	// Offset is the byte offset of the start of the line in the decompressed, UTF-8 file, or -1 if it is unknown
+	Offset int64 `json:"offset"`
+	// Parser is the line parser that failed
+	Parser string `json:"parser"`
+	// Error is the reason that the line could not be parsed
+	Error string `json:"error"`
+	// Text is the line, or the lines of a record joined by newlines
+	Text string `json:"text"`
+}
+
+// Write appends an entry to an error log, as a line of JSON
+func Write(w io.Writer, e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// A Reader reads the entries of an error log
+type Reader struct {
+	scanner *bufio.Scanner
+	line    int64
+}
+
+// NewReader creates a Reader
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxEntryLength)
+	return &Reader{scanner: scanner}
+}
+
+// Next returns the next entry, or io.EOF at the end of the log
+func (r *Reader) Next() (Entry, error) {
+	for r.scanner.Scan() {
+		r.line++
+		if len(r.scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var e Entry
+		if err := json.Unmarshal(r.scanner.Bytes(), &e); err != nil {
+			return Entry{}, &SyntaxError{Line: r.line, Err: err}
+		}
+		return e, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return Entry{}, err
+	}
+	return Entry{}, io.EOF
+}
+
+// A SyntaxError occurs when a line of the error log is not an entry, e.g. an error log from an older version
+type SyntaxError struct {
+	Line int64
+	Err  error
+}
+
+func (e *SyntaxError) Error() string {
+	return "line " + strconv.FormatInt(e.Line, 10) + " of the error log is not valid JSON: " + e.Err.Error()
+}
+
+// A Skipped file is a line of the skip log
+type Skipped struct {
+	Path   string
+	Reason string
+}
+
+// ReadSkipped reads the files of a skip log, which has a path and reason separated by a tab on each line
+func ReadSkipped(r io.Reader) ([]Skipped, error) {
+	var skipped []Skipped
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		// paths may contain tabs, but reasons don't
+		idx := strings.LastIndex(line, "\t")
+		if idx < 0 {
+			skipped = append(skipped, Skipped{Path: line})
+			continue
+		}
+		skipped = append(skipped, Skipped{Path: line[:idx], Reason: line[idx+1:]})
+	}
+	return skipped, scanner.Err()
+}
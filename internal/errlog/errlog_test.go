@@ -0,0 +1,54 @@
+package errlog
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	entries := []Entry{
+		{Source: "a.zip/users.txt", Line: 3, Offset: 120, Parser: "collections", Error: "Incorrect number of columns", Text: "no\tdelimiter"},
+		{Source: "stealer.txt", Line: 9, Offset: -1, Parser: "infostealer", Error: "Incomplete record", Text: "URL: x\nLogin: y"},
+	}
+
+	var buf bytes.Buffer
+	for _, e := range entries {
+		if err := Write(&buf, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := strings.Count(buf.String(), "\n"); n != len(entries) {
+		t.Errorf("got %d lines, want %d", n, len(entries))
+	}
+
+	r := NewReader(&buf)
+	for i, want := range entries {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("entry %d = %+v, want %+v", i, got, want)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+
+	if _, err := NewReader(strings.NewReader("a raw line from an old error log\n")).Next(); err == nil {
+		t.Error("expected an error for a line that is not JSON")
+	}
+}
+
+func TestReadSkipped(t *testing.T) {
+	skipped, err := ReadSkipped(strings.NewReader("a.zip/secret.txt\tencrypted\nweird\tname.txt\tbinary content\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Skipped{{"a.zip/secret.txt", "encrypted"}, {"weird\tname.txt", "binary content"}}
+	if len(skipped) != len(want) || skipped[0] != want[0] || skipped[1] != want[1] {
+		t.Errorf("got %+v, want %+v", skipped, want)
+	}
+}
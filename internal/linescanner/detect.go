@@ -0,0 +1,142 @@
+package linescanner
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// format is the container or compression format of a stream
+type format int
+
+const (
+	formatText format = iota
+	formatGzip
+	formatBzip2
+	formatZlib
+	formatTar
+	formatZip
+)
+
+// tar headers are 512 bytes long and contain "ustar" at offset 257
+const (
+	tarHeaderSize  = 512
+	tarMagicOffset = 257
+)
+
+var (
+	magicGzip     = []byte{0x1f, 0x8b}
+	magicBzip2    = []byte("BZh")
+	magicZip      = []byte("PK\x03\x04")
+	magicZipEmpty = []byte("PK\x05\x06")
+	magicTar      = []byte("ustar")
+)
+
+// detectFormat sniffs the magic bytes at the start of the stream without consuming them
+func detectFormat(r *bufio.Reader) format {
+	// a short stream returns an error, but still returns what is available
+	magic, _ := r.Peek(tarHeaderSize)
+
+	switch {
+	case bytes.HasPrefix(magic, magicGzip):
+		return formatGzip
+	case bytes.HasPrefix(magic, magicBzip2) && len(magic) > 3 && magic[3] >= '1' && magic[3] <= '9':
+		return formatBzip2
+	case bytes.HasPrefix(magic, magicZip) || bytes.HasPrefix(magic, magicZipEmpty):
+		return formatZip
+	case len(magic) >= tarMagicOffset+len(magicTar) && bytes.Equal(magic[tarMagicOffset:tarMagicOffset+len(magicTar)], magicTar):
+		return formatTar
+	case isZlib(magic):
+		return formatZlib
+	}
+	return formatText
+}
+
+// isZlib checks for a zlib header using the deflate method, a 32K window and no preset dictionary.
+// Only the FLG values written by common compression levels are accepted to avoid matching text starting with 'x'
+func isZlib(magic []byte) bool {
+	if len(magic) < 2 || magic[0] != 0x78 {
+		return false
+	}
+	switch magic[1] {
+	case 0x01, 0x5e, 0x9c, 0xda:
+		return true
+	}
+	return false
+}
+
+// trimCompressionExt removes the file extension added by compressing a file, e.g. dump.txt.gz -> dump.txt
+func trimCompressionExt(name string, f format) string {
+	var exts map[string]string
+	switch f {
+	case formatGzip:
+		exts = map[string]string{".gz": "", ".gzip": "", ".tgz": ".tar"}
+	case formatBzip2:
+		exts = map[string]string{".bz2": "", ".bzip2": "", ".tbz": ".tar", ".tbz2": ".tar"}
+	case formatZlib:
+		exts = map[string]string{".zz": "", ".zlib": ""}
+	}
+
+	lower := strings.ToLower(name)
+	for ext, replacement := range exts {
+		if strings.HasSuffix(lower, ext) {
+			return name[:len(name)-len(ext)] + replacement
+		}
+	}
+	return name
+}
+
+// sniffSize is the number of bytes read from the start of a text file to check that it is not binary
+const sniffSize = 8 * 1024
+
+// maxBinaryRatio is the maximum proportion of control characters and invalid UTF-8 in a text file
+const maxBinaryRatio = 0.1
+
+// isBinary classifies a sample of UTF-8 text as binary if it contains a null byte or many control characters
+func isBinary(sample []byte) bool {
+	if len(sample) == 0 {
+		return false
+	}
+	if bytes.IndexByte(sample, 0) >= 0 {
+		return true
+	}
+
+	var suspicious int
+	for i := 0; i < len(sample); {
+		r, size := utf8.DecodeRune(sample[i:])
+		switch {
+		case r == utf8.RuneError && i+size < len(sample):
+			// invalid UTF-8 which wasn't cut off at the end of the sample
+			suspicious++
+		case r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' && r != '\v' && r != 0x1b:
+			suspicious++
+		}
+		i += size
+	}
+	return float64(suspicious)/float64(len(sample)) > maxBinaryRatio
+}
+
+// extensionSkipReason checks the file extension against the allow and deny lists, returning why the file should be skipped
+func extensionSkipReason(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	for _, deny := range DenyExtensions {
+		if ext == strings.ToLower(deny) {
+			return "denied extension " + ext
+		}
+	}
+
+	if len(AllowExtensions) == 0 {
+		return ""
+	}
+	for _, allow := range AllowExtensions {
+		if ext == strings.ToLower(allow) {
+			return ""
+		}
+	}
+	if ext == "" {
+		return "no extension"
+	}
+	return "extension " + ext + " is not allowed"
+}
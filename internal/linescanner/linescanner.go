@@ -2,38 +2,238 @@ package linescanner
 
 import (
 	"archive/tar"
+	"archive/zip"
 	"bufio"
+	"compress/bzip2"
 	"compress/gzip"
+	"compress/zlib"
+	"errors"
 	"io"
+	"io/ioutil"
 	"os"
-	"strings"
+
+	"github.com/darkmattermatt/dumpdb/pkg/transcode"
 )
 
-// LineScanner creates a bufio.Scanner from a file, decompressing the file if necessary.
-func LineScanner(path string, callback func(string, *bufio.Scanner) error) error {
-	if strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz") {
-		return TarGzLineScanner(path, callback)
+// maxCompressionLayers limits the number of stacked decompressors, which guards against gzip quines
+const maxCompressionLayers = 4
+
+var (
+	// SkipCallback is called with the name of each archive member that cannot be read and the reason why
+	SkipCallback func(name, reason string)
+
+	// EncodingCallback is called with the name and detected character encoding of each text file
+	EncodingCallback func(name, encoding string)
+
+	// Encoding is the character encoding of text files, or "auto" to detect it for each file. Text is transcoded to UTF-8
+	Encoding = transcode.Auto
+
+	// Sniff skips text files that look like binary files from their first few KB
+	Sniff = true
+
+	// AllowExtensions limits text files to those with one of the extensions, e.g. .txt. Empty means allow any extension
+	AllowExtensions []string
+
+	// DenyExtensions skips text files with any of the extensions
+	DenyExtensions []string
+
+	// MaxDepth is the maximum number of nested archives to open. 1 opens archives but not archives inside them
+	MaxDepth = 5
+
+	// MaxSize is the maximum number of bytes to decompress from a single file, to guard against zip bombs. 0 means no limit
+	MaxSize int64
+
+	// MaxLineLength is the maximum number of bytes in a line. Longer lines are skipped and written to Quarantine
+	MaxLineLength = 1024 * 1024
+
+	// Quarantine is written to with lines longer than MaxLineLength, prefixed with the file name, line number and byte offset
+	Quarantine io.Writer
+)
+
+var errSizeLimit = errors.New("Decompressed size limit exceeded")
+
+// scanState tracks the progress of scanning a single file, which may contain nested archives
+type scanState struct {
+	callback     func(string, *Scanner) error
+	decompressed int64
+	exceeded     bool
+	// archive is the name of the outermost archive
+	archive string
+	// current is the innermost file being read, which is skipped if it exceeds the size limit
+	current string
+}
+
+// LineScanner creates a Scanner from a file, decompressing the file if necessary.
+// The format is detected from the contents of the file rather than the file extension.
+// Archive members are named by joining the archive name and the member name, e.g. outer.zip/inner.tar.gz/file.txt
+func LineScanner(path string, callback func(string, *Scanner) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
 	}
-	return TextLineScanner(path, callback)
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		// named pipes, character devices, etc. cannot be read more than once
+		return ReaderLineScanner(path, file, callback)
+	}
+
+	s := &scanState{callback: callback}
+	r := bufio.NewReader(file)
+	if detectFormat(r) == formatZip {
+		return s.finish(path, s.openZip(path, file, info.Size(), 0))
+	}
+	return s.finish(path, s.scanStream(path, path, r, 0, 0))
 }
 
-// TarGzLineScanner creates a bufio.Scanner from a .tar.gz file.
-func TarGzLineScanner(path string, callback func(string, *bufio.Scanner) error) error {
-	// open tar.gz
-	tarGz, err := os.Open(path)
+// ReaderLineScanner creates a Scanner from a stream such as standard input or a named pipe.
+// Zip archives are copied to a temporary file because they cannot be read from a stream.
+func ReaderLineScanner(name string, r io.Reader, callback func(string, *Scanner) error) error {
+	s := &scanState{callback: callback}
+	return s.finish(name, s.scanStream(name, name, bufio.NewReader(r), 0, 0))
+}
+
+// ZipLineScanner creates a Scanner for each file in a .zip file.
+// The name passed to `callback` is the path of the zip file joined with the path of the member.
+func ZipLineScanner(path string, callback func(string, *Scanner) error) error {
+	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
-	defer tarGz.Close()
+	defer file.Close()
 
-	// decompress
-	gzf, err := gzip.NewReader(tarGz)
+	info, err := file.Stat()
 	if err != nil {
 		return err
 	}
-	tarReader := tar.NewReader(gzf)
 
-	// loop through lines in tar.gz files
+	s := &scanState{callback: callback}
+	return s.finish(path, s.openZip(path, file, info.Size(), 0))
+}
+
+func skip(name, reason string) {
+	if SkipCallback != nil {
+		SkipCallback(name, reason)
+	}
+}
+
+// finish skips the file that was being read when the size limit was exceeded. Any other error is returned, even after
+// the limit was exceeded
+func (s *scanState) finish(path string, err error) error {
+	if !s.exceeded || (err != nil && !errors.Is(err, errSizeLimit)) {
+		return err
+	}
+
+	name, reason := s.current, "decompressed size limit exceeded"
+	if name == "" {
+		name = path
+	} else if name != path {
+		// the files before it have been read, and the files after it are never reached
+		reason += ", the rest of " + path + " was not read"
+	}
+	skip(name, reason)
+	return nil
+}
+
+// scanStream detects the format of `r` and stacks decompressors until an archive or text is found.
+// `name` is used as the prefix of archive members, `textName` has compression extensions removed.
+// `depth` is the number of archives that `r` is inside, `layers` is the number of decompressors applied
+func (s *scanState) scanStream(name, textName string, r *bufio.Reader, depth, layers int) error {
+	// the file that contains this one is current again afterwards, unless this file exceeded the size limit
+	parent := s.current
+	s.current = textName
+	defer func() {
+		if !s.exceeded {
+			s.current = parent
+		}
+	}()
+
+	f := detectFormat(r)
+	switch f {
+	case formatGzip, formatBzip2, formatZlib:
+		if layers >= maxCompressionLayers {
+			skip(textName, "too many compression layers")
+			return nil
+		}
+
+		var decompressed io.Reader
+		switch f {
+		case formatGzip:
+			gzf, err := gzip.NewReader(r)
+			if err != nil {
+				return err
+			}
+			defer gzf.Close()
+			decompressed = gzf
+		case formatBzip2:
+			decompressed = bzip2.NewReader(r)
+		case formatZlib:
+			zf, err := zlib.NewReader(r)
+			if err != nil {
+				return err
+			}
+			defer zf.Close()
+			decompressed = zf
+		}
+		return s.scanStream(name, trimCompressionExt(textName, f), bufio.NewReader(s.limit(decompressed)), depth, layers+1)
+
+	case formatTar, formatZip:
+		if s.tooDeep(name, depth) {
+			return nil
+		}
+		if f == formatTar {
+			return s.scanTar(name, tar.NewReader(r), depth+1)
+		}
+		return s.spoolZip(name, r, depth+1)
+	}
+
+	return s.scanText(textName, r)
+}
+
+// scanText transcodes a text file to UTF-8 and scans its lines, skipping binary files and files with the wrong extension
+func (s *scanState) scanText(name string, r io.Reader) error {
+	if reason := extensionSkipReason(name); reason != "" {
+		skip(name, reason)
+		return nil
+	}
+
+	utf8Reader, encoding, err := transcode.NewReader(r, Encoding)
+	if err != nil {
+		return err
+	}
+
+	if Sniff {
+		br := bufio.NewReaderSize(utf8Reader, sniffSize)
+		// a short file returns an error, but still returns what is available
+		sample, _ := br.Peek(sniffSize)
+		if isBinary(sample) {
+			skip(name, "binary content")
+			return nil
+		}
+		utf8Reader = br
+	}
+
+	if EncodingCallback != nil {
+		EncodingCallback(name, encoding)
+	}
+
+	// iterate through the lines in the file
+	lineScanner := newScanner(name, utf8Reader)
+	lineScanner.archive = s.archive
+	return s.callback(name, lineScanner)
+}
+
+// scanTar scans each file in a tarball
+func (s *scanState) scanTar(name string, tarReader *tar.Reader, depth int) error {
+	if s.archive == "" {
+		s.archive = name
+	}
+
+	// loop through files in the tarball
 	for {
 		header, err := tarReader.Next()
 		if err != nil {
@@ -48,9 +248,8 @@ func TarGzLineScanner(path string, callback func(string, *bufio.Scanner) error)
 			continue
 		}
 
-		// iterate through the lines in the file
-		lineScanner := bufio.NewScanner(tarReader)
-		err = callback(header.Name, lineScanner)
+		memberName := name + "/" + header.Name
+		err = s.scanStream(memberName, memberName, bufio.NewReader(tarReader), depth, 0)
 		if err != nil {
 			return err
 		}
@@ -58,14 +257,106 @@ func TarGzLineScanner(path string, callback func(string, *bufio.Scanner) error)
 	return nil
 }
 
-// TextLineScanner creates a bufio.Scanner from a plain text file.
-func TextLineScanner(path string, callback func(string, *bufio.Scanner) error) error {
-	file, err := os.Open(path)
+// spoolZip copies a zip archive from a stream into a temporary file, because zip archives need random access
+func (s *scanState) spoolZip(name string, r io.Reader, depth int) error {
+	tmp, err := ioutil.TempFile("", "dumpdb-*.zip")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		return err
+	}
+	return s.scanZip(name, tmp, size, depth)
+}
+
+// tooDeep skips an archive that is inside `depth` archives if that is more than MaxDepth allows
+func (s *scanState) tooDeep(name string, depth int) bool {
+	if depth >= MaxDepth {
+		skip(name, "maximum archive depth exceeded")
+		return true
+	}
+	return false
+}
+
+// openZip scans a zip file that is on disk, which is inside `depth` archives
+func (s *scanState) openZip(name string, r io.ReaderAt, size int64, depth int) error {
+	if s.tooDeep(name, depth) {
+		return nil
+	}
+	return s.scanZip(name, r, size, depth+1)
+}
+
+// scanZip scans each file in a zip archive
+func (s *scanState) scanZip(name string, r io.ReaderAt, size int64, depth int) error {
+	// archive/zip transparently handles ZIP64
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	if s.archive == "" {
+		s.archive = name
+	}
 
-	lineScanner := bufio.NewScanner(file)
-	return callback(path, lineScanner)
+	for _, f := range zipReader.File {
+		memberName := name + "/" + f.Name
+
+		// skip directories, symlinks, etc.
+		if !f.Mode().IsRegular() {
+			continue
+		}
+
+		// bit 0 of the general purpose flags is set for encrypted files
+		if f.Flags&0x1 != 0 {
+			skip(memberName, "encrypted")
+			continue
+		}
+
+		err = s.scanZipFile(memberName, f, depth)
+		if err == zip.ErrAlgorithm {
+			skip(memberName, "unsupported compression method")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *scanState) scanZipFile(name string, f *zip.File, depth int) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return s.scanStream(name, name, bufio.NewReader(s.limit(rc)), depth, 0)
+}
+
+// limit counts the bytes read from a decompressor, returning errSizeLimit once MaxSize is exceeded
+func (s *scanState) limit(r io.Reader) io.Reader {
+	return &limitReader{r: r, s: s}
+}
+
+type limitReader struct {
+	r io.Reader
+	s *scanState
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.s.exceeded {
+		return 0, errSizeLimit
+	}
+
+	n, err := l.r.Read(p)
+	l.s.decompressed += int64(n)
+	if MaxSize > 0 && l.s.decompressed > MaxSize {
+		l.s.exceeded = true
+		return n, errSizeLimit
+	}
+	return n, err
 }
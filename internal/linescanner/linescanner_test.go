@@ -0,0 +1,188 @@
+package linescanner
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func checkErr(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createTarGz creates a .tar.gz in memory containing the files
+func createTarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	for name, contents := range files {
+		checkErr(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(contents))
+		checkErr(t, err)
+	}
+	checkErr(t, tw.Close())
+	checkErr(t, gzw.Close())
+	return buf.Bytes()
+}
+
+// TestNestedArchives tests that archives inside archives are opened and named by their full path
+func TestNestedArchives(t *testing.T) {
+	dir, err := ioutil.TempDir("", "linescanner")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+
+	// outer.zip contains inner.tar.gz and an encrypted file
+	path := filepath.Join(dir, "outer.zip")
+	f, err := os.Create(path)
+	checkErr(t, err)
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("inner.tar.gz")
+	checkErr(t, err)
+	_, err = w.Write(createTarGz(t, map[string]string{"file.txt": "a@b.com:pass\nc@d.com:pass\n"}))
+	checkErr(t, err)
+	_, err = zw.CreateHeader(&zip.FileHeader{Name: "secret.txt", Flags: 0x1})
+	checkErr(t, err)
+	checkErr(t, zw.Close())
+	checkErr(t, f.Close())
+
+	var skipped []string
+	SkipCallback = func(name, reason string) {
+		skipped = append(skipped, name)
+	}
+	defer func() { SkipCallback = nil }()
+
+	lines := make(map[string]int)
+	err = LineScanner(path, func(name string, s *Scanner) error {
+		if s.Archive() != path || s.Member() != "inner.tar.gz/file.txt" {
+			t.Errorf("Expected archive %s and member inner.tar.gz/file.txt, found %s and %s", path, s.Archive(), s.Member())
+		}
+		for s.Scan() {
+			lines[name]++
+		}
+		return s.Err()
+	})
+	checkErr(t, err)
+
+	if n := lines[path+"/inner.tar.gz/file.txt"]; n != 2 {
+		t.Errorf("Expected 2 lines from %s/inner.tar.gz/file.txt, found %d (%v)", path, n, lines)
+	}
+	if len(skipped) != 1 || skipped[0] != path+"/secret.txt" {
+		t.Errorf("Expected encrypted file to be skipped, found %v", skipped)
+	}
+
+	// don't open nested archives
+	MaxDepth = 1
+	defer func() { MaxDepth = 5 }()
+	skipped = nil
+	err = LineScanner(path, func(name string, s *Scanner) error {
+		t.Errorf("Did not expect to scan %s", name)
+		return nil
+	})
+	checkErr(t, err)
+	if len(skipped) != 2 || skipped[0] != path+"/inner.tar.gz" {
+		t.Errorf("Expected nested archive to be skipped, found %v", skipped)
+	}
+
+	// don't open any archives, like tarballs are skipped at the top level
+	MaxDepth = 0
+	skipped = nil
+	for _, scan := range []func(string, func(string, *Scanner) error) error{LineScanner, ZipLineScanner} {
+		err = scan(path, func(name string, s *Scanner) error {
+			t.Errorf("Did not expect to scan %s", name)
+			return nil
+		})
+		checkErr(t, err)
+	}
+	if len(skipped) != 2 || skipped[0] != path || skipped[1] != path {
+		t.Errorf("Expected the zip file to be skipped, found %v", skipped)
+	}
+}
+
+// TestOversizedLines tests that lines longer than MaxLineLength are quarantined without stopping the scan
+func TestOversizedLines(t *testing.T) {
+	var quarantine bytes.Buffer
+	Quarantine = &quarantine
+	MaxLineLength = 10
+	defer func() {
+		Quarantine = nil
+		MaxLineLength = 1024 * 1024
+	}()
+
+	long := strings.Repeat("x", 100)
+	s := newScanner("test.txt", strings.NewReader("short\r\n"+long+"\nabc\n0123456789a\nlast"))
+
+	var lines []string
+	var offsets []int64
+	for s.Scan() {
+		lines = append(lines, s.Text())
+		offsets = append(offsets, s.Offset())
+	}
+	checkErr(t, s.Err())
+
+	if strings.Join(lines, ",") != "short,abc,last" {
+		t.Errorf("Expected lines short,abc,last, found %v", lines)
+	}
+	if offsets[1] != 108 || offsets[2] != 124 || s.Line() != 5 {
+		t.Errorf("Unexpected line offsets %v or line number %d", offsets, s.Line())
+	}
+	if s.Oversized() != 2 {
+		t.Errorf("Expected 2 oversized lines, found %d", s.Oversized())
+	}
+
+	want := "test.txt\t2\t7\t" + long + "\ntest.txt\t4\t112\t0123456789a\n"
+	if quarantine.String() != want {
+		t.Errorf("Expected quarantine %q, found %q", want, quarantine.String())
+	}
+}
+
+// TestSizeLimit tests that only the member that crosses MaxSize is skipped, and that other errors are still returned
+func TestSizeLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "linescanner")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "big.tar.gz")
+	checkErr(t, ioutil.WriteFile(path, createTarGz(t, map[string]string{"big.txt": strings.Repeat("a@b.com:pass\n", 1000)}), 0644))
+
+	var skipped, reasons []string
+	SkipCallback = func(name, reason string) {
+		skipped = append(skipped, name)
+		reasons = append(reasons, reason)
+	}
+	MaxSize = 4096
+	defer func() {
+		SkipCallback = nil
+		MaxSize = 0
+	}()
+
+	err = LineScanner(path, func(name string, s *Scanner) error {
+		for s.Scan() {
+		}
+		return s.Err()
+	})
+	checkErr(t, err)
+	if len(skipped) != 1 || skipped[0] != path+"/big.txt" || !strings.Contains(reasons[0], "the rest of "+path) {
+		t.Errorf("Expected the member that exceeded the limit to be skipped, found %v %v", skipped, reasons)
+	}
+
+	// an error from the callback is returned, even though the limit was exceeded
+	skipped = nil
+	stop := errors.New("stop")
+	err = LineScanner(path, func(name string, s *Scanner) error {
+		for s.Scan() {
+		}
+		return stop
+	})
+	if err != stop || len(skipped) != 0 {
+		t.Errorf("Expected the callback error, found %v and skipped %v", err, skipped)
+	}
+}
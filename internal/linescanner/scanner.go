@@ -0,0 +1,154 @@
+package linescanner
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const startBufSize = 64 * 1024
+
+// Scanner is a bufio.Scanner that tracks the position of the current line and skips lines longer than MaxLineLength.
+// Skipped lines are written to Quarantine, prefixed with the file name, line number and byte offset.
+type Scanner struct {
+	*bufio.Scanner
+
+	name      string
+	archive   string
+	line      int64
+	offset    int64
+	pos       int64
+	oversized int64
+
+	// discarding is true while skipping the remainder of an oversized line
+	discarding bool
+}
+
+func newScanner(name string, r io.Reader) *Scanner {
+	s := &Scanner{
+		Scanner: bufio.NewScanner(r),
+		name:    name,
+	}
+
+	// allow room for a \r\n after a line of the maximum length
+	s.Buffer(make([]byte, 0, startBufSize), MaxLineLength+2)
+	s.Split(s.split)
+	return s
+}
+
+// Archive returns the name of the outermost archive that the file is in, or an empty string if it is not in an archive
+func (s *Scanner) Archive() string {
+	return s.archive
+}
+
+// Member returns the name of the file inside its outermost archive, or the name of the file if it is not in an archive
+func (s *Scanner) Member() string {
+	if s.archive == "" {
+		return s.name
+	}
+	return strings.TrimPrefix(s.name, s.archive+"/")
+}
+
+// Line returns the line number of the most recent line, starting at 1
+func (s *Scanner) Line() int64 {
+	return s.line
+}
+
+// Offset returns the byte offset of the start of the most recent line in the decompressed, UTF-8 stream
+func (s *Scanner) Offset() int64 {
+	return s.offset
+}
+
+// Oversized returns the number of lines longer than MaxLineLength that were skipped
+func (s *Scanner) Oversized() int64 {
+	return s.oversized
+}
+
+// split is a bufio.SplitFunc that wraps bufio.ScanLines, tracking offsets and quarantining oversized lines.
+// Oversized lines are consumed in the same call as the following line, because bufio.Scanner stops at EOF if no token is returned
+func (s *Scanner) split(data []byte, atEOF bool) (int, []byte, error) {
+	start := 0
+	for {
+		rest := data[start:]
+
+		if s.discarding {
+			i := bytes.IndexByte(rest, '\n')
+			if i < 0 {
+				s.quarantine(rest)
+				s.pos += int64(len(rest))
+				if atEOF {
+					// the oversized line was the last line
+					s.endOversized()
+				}
+				return len(data), nil, nil
+			}
+			s.quarantine(dropCR(rest[:i]))
+			s.endOversized()
+			s.pos += int64(i + 1)
+			start += i + 1
+			continue
+		}
+
+		advance, token, err := bufio.ScanLines(rest, atEOF)
+		if err != nil {
+			return start + advance, token, err
+		}
+
+		if token == nil {
+			if len(rest) > MaxLineLength {
+				// the buffer is full and there is no newline
+				s.startOversized()
+				continue
+			}
+			// request more data
+			return start, nil, nil
+		}
+
+		if len(token) > MaxLineLength {
+			// a complete line which is too long
+			s.startOversized()
+			s.quarantine(token)
+			s.endOversized()
+			s.pos += int64(advance)
+			start += advance
+			continue
+		}
+
+		s.line++
+		s.offset = s.pos
+		s.pos += int64(advance)
+		return start + advance, token, nil
+	}
+}
+
+func (s *Scanner) startOversized() {
+	s.line++
+	s.oversized++
+	s.discarding = true
+	if Quarantine != nil {
+		io.WriteString(Quarantine, s.name+"\t"+strconv.FormatInt(s.line, 10)+"\t"+strconv.FormatInt(s.pos, 10)+"\t")
+	}
+}
+
+func (s *Scanner) quarantine(data []byte) {
+	if Quarantine != nil {
+		Quarantine.Write(data)
+	}
+}
+
+func (s *Scanner) endOversized() {
+	s.discarding = false
+	if Quarantine != nil {
+		io.WriteString(Quarantine, "\n")
+	}
+}
+
+// dropCR drops a terminal \r from the data
+func dropCR(data []byte) []byte {
+	if len(data) > 0 && data[len(data)-1] == '\r' {
+		return data[0 : len(data)-1]
+	}
+	return data
+}
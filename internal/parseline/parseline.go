@@ -1,23 +1,79 @@
 package parseline
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 // ErrInvalidLineParser occurs when a line parser that does not exists is requested
 var ErrInvalidLineParser = errors.New("The requested line parser does not exist")
 
+// ErrNoRecord occurs when a single line does not make a complete record, e.g. one line of a multi-line record
+var ErrNoRecord = errors.New("The line does not contain a complete record")
+
 var lineParsers = make(map[string]func(line, source string) (Record, error))
 
-// ParseLine parses a single line with the requested line parser
+// streamParsers create a StreamParser for each file, for parsers that need more than one line at a time
+var streamParsers = make(map[string]func() StreamParser)
+
+// ParseLine parses a single line with the requested line parser. For stream parsers it returns the first record
 func ParseLine(name, line, source string) (Record, error) {
+	name = parserName(name)
 	parser, ok := lineParsers[name]
 	if ok {
 		return parser(line, source)
 	}
-	return Record{}, ErrInvalidLineParser
+	if p, ok := execParsers[name]; ok {
+		return p.parseLine(line, source)
+	}
+
+	newParser, ok := streamParsers[name]
+	if !ok {
+		return Record{}, ErrInvalidLineParser
+	}
+
+	p := newParser()
+	result, resultErr := Record{}, ErrNoRecord
+	emit := func(_ string, _ int64, r Record, err error) {
+		if resultErr == ErrNoRecord {
+			result, resultErr = r, err
+		}
+	}
+	if err := p.Parse(line, source, 1, emit); err != nil {
+		return Record{}, err
+	}
+	if err := p.Flush(); err != nil {
+		return Record{}, err
+	}
+	return result, resultErr
+}
+
+// IsLineParser checks if the line parser parses each line on its own, so that every record comes from a single line
+func IsLineParser(name string) bool {
+	_, ok := lineParsers[parserName(name)]
+	return ok
+}
+
+// IsExecParser checks if the line parser runs an external process. Like line parsers, it gives one result per line
+func IsExecParser(name string) bool {
+	_, ok := execParsers[parserName(name)]
+	return ok
 }
 
 // ParserExists checks if the specified line parser exists
 func ParserExists(name string) bool {
+	name = parserName(name)
 	_, ok := lineParsers[name]
+	if !ok {
+		_, ok = streamParsers[name]
+	}
+	if !ok {
+		_, ok = execParsers[name]
+	}
 	return ok
 }
+
+// parserName normalises the name of a line parser. Names are case-insensitive, because the keys of the config file are
+func parserName(name string) string {
+	return strings.ToLower(name)
+}
@@ -0,0 +1,113 @@
+package parseline
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Auto is the line parser name that detects the line parser of each file from a sample of its lines
+const Auto = "auto"
+
+var (
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	// hex digests (MD5, SHA1, SHA256, ...) and crypt(3) style hashes like $2y$10$...
+	hashPattern = regexp.MustCompile(`^(?:[0-9a-fA-F]{16,128}|\$[0-9a-z]+\$\S+)$`)
+)
+
+// Parsers returns the names of the line parsers that auto detection chooses from, in alphabetical order. External line
+// parsers are left out, so that sampling a file doesn't start every configured subprocess
+func Parsers() []string {
+	names := make([]string, 0, len(lineParsers)+len(streamParsers))
+	for name := range lineParsers {
+		names = append(names, name)
+	}
+	for name := range streamParsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// scoreRecord rates how plausible a parsed record is, between 0 and 1
+func scoreRecord(r Record) float64 {
+	score := 1.0
+
+	// a credential needs someone to identify and a secret
+	if r.Email == "" && r.EmailRev == "" && r.Username == "" {
+		score *= 0.5
+	}
+	if r.Password == "" && r.Hash == "" {
+		score *= 0.75
+	}
+
+	if r.Email != "" && !emailPattern.MatchString(r.Email) {
+		score *= 0.5
+	}
+	if r.EmailRev != "" && !emailPattern.MatchString(r.EmailRev) {
+		score *= 0.5
+	}
+	if r.Hash != "" && !hashPattern.MatchString(r.Hash) {
+		score *= 0.5
+	}
+	if len(r.Username) > 64 || strings.ContainsAny(r.Username, " \t") {
+		score *= 0.75
+	}
+	// surrounding whitespace means the line was split in the wrong place, e.g. `Password: hunter2` split at the colon
+	for _, v := range []string{r.Username, r.Email, r.Hash, r.Password} {
+		if strings.TrimSpace(v) != v {
+			score *= 0.75
+			break
+		}
+	}
+	// quotes and brackets mean structured data such as JSON was split like a combo list
+	if strings.ContainsAny(r.Username, "{}[]\"") || strings.ContainsAny(r.Email, "{}[]\"") {
+		score *= 0.5
+	}
+	return score
+}
+
+// Score rates how well a line parser parses the lines, between 0 and 1.
+// It is the average score of the records, reduced by the fraction of lines that fail to parse
+func Score(name string, lines []string) float64 {
+	parser, err := Stream(name)
+	if err != nil || len(lines) == 0 {
+		return 0
+	}
+
+	var total float64
+	var records, failed int
+	emit := func(_ string, _ int64, r Record, err error) {
+		if err != nil {
+			failed++
+			return
+		}
+		total += scoreRecord(r)
+		records++
+	}
+	for i, line := range lines {
+		if parser.Parse(line, "", int64(i+1), emit) != nil {
+			return 0
+		}
+	}
+	if parser.Flush() != nil || records == 0 {
+		return 0
+	}
+
+	if failed > len(lines) {
+		failed = len(lines)
+	}
+	return total / float64(records) * float64(len(lines)-failed) / float64(len(lines))
+}
+
+// Detect returns the line parser that scores best on the lines, and its score.
+// Ties are broken by the parser name so that the choice is repeatable
+func Detect(lines []string) (string, float64) {
+	best, bestScore := "", 0.0
+	for _, name := range Parsers() {
+		if score := Score(name, lines); score > bestScore {
+			best, bestScore = name, score
+		}
+	}
+	return best, bestScore
+}
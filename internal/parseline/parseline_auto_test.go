@@ -0,0 +1,23 @@
+package parseline
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		lines []string
+		want  string
+	}{
+		{[]string{"a@example.com:hunter2", "b@example.org:letmein"}, "collections"},
+		{[]string{"1-|--|-a@example.com-|-5f4dcc3b5aa765d61d8327deb882cf99-|-hint-|--", "2-|--|-b@example.com-|-abcdef0123456789-|--|--"}, "adobe"},
+	}
+	for _, tt := range tests {
+		got, score := Detect(tt.lines)
+		if got != tt.want {
+			t.Errorf("Detect(%q) = %s (%.2f), want %s", tt.lines, got, score, tt.want)
+		}
+	}
+
+	if _, score := Detect([]string{"just some prose", "without any delimiters"}); score >= 0.5 {
+		t.Errorf("Detect(prose) score = %.2f, want below 0.5", score)
+	}
+}
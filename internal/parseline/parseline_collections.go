@@ -28,19 +28,23 @@ func init() {
 		}
 
 		result.Password = r[1]
-
-		// roughly check if it is an email address or a username
-		idxAt := strings.Index(r[0], "@")
-		idxDot := strings.LastIndex(r[0], ".")
-
-		if idxAt > 0 && idxDot > idxAt+1 {
-			// is email
-			result.Email = r[0]
-		} else {
-			// not email
-			result.Username = r[0]
-		}
+		result.Source = source
+		setLogin(&result, r[0])
 
 		return result, nil
 	}
 }
+
+// setLogin sets the email address or the username of a record, by roughly checking if the login is an email address
+func setLogin(r *Record, login string) {
+	idxAt := strings.Index(login, "@")
+	idxDot := strings.LastIndex(login, ".")
+
+	if idxAt > 0 && idxDot > idxAt+1 {
+		// is email
+		r.Email = login
+	} else {
+		// not email
+		r.Username = login
+	}
+}
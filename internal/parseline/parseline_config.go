@@ -0,0 +1,244 @@
+package parseline
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// A ParserConfig describes a line parser that is defined in the config file instead of in Go
+type ParserConfig struct {
+	// Delimiters are tried in order until one splits the line into the expected number of columns
+	Delimiters []string
+	// Regex is matched against the line instead of splitting it. The named groups are mapped to fields
+	Regex string
+	// Columns are the fields of each column when splitting the line. The last column contains the rest of the line
+	Columns []string
+	// Source overrides the source of every record, e.g. "adobe"
+	Source string
+	// Exec is the command and arguments of a long-running subprocess that parses lines, instead of splitting or a regex.
+	// It is given one line per line of stdin, and writes one result per line to stdout
+	Exec []string
+	// Format is the output format of Exec, jsonl (default) or tsv
+	Format string
+	// Batch is the number of lines written to Exec at a time
+	Batch int
+	// CSV parses RFC 4180 CSV files with a header row. Delimiters may contain a single delimiter, which is detected otherwise.
+	// Columns are used for files without a header row
+	CSV bool
+	// SQL parses the rows of INSERT statements and COPY blocks in SQL dumps. Columns are used for tables without column names
+	SQL bool
+	// Tables are the SQL tables to read. By default every table with a column that maps to a field is read
+	Tables []string
+	// JSON parses JSON lines and arrays of JSON objects. Nested fields are flattened into dotted paths
+	JSON bool
+	// Paths map JSON paths to fields, in the format path=field, e.g. user.email=email.
+	// By default the last part of each path is mapped by the same synonyms as CSV headers
+	Paths []string
+	// Mapping maps CSV header names or SQL column names to fields, in addition to the built-in synonyms such as password_hash
+	Mapping map[string]string
+}
+
+// field names that can be used for columns and regex groups, in addition to the Record fields
+const (
+	// fieldLogin is an email address or username, decided by the same heuristic as the collections parser
+	fieldLogin = "login"
+	// fieldSkip discards the column
+	fieldSkip = "-"
+)
+
+// Register adds a line parser that is defined by a ParserConfig. The name is case-insensitive
+func Register(name string, pc ParserConfig) error {
+	name = parserName(name)
+	if name == "" {
+		return errors.New("Line parser name must not be empty")
+	}
+	if name == Auto || ParserExists(name) {
+		return errors.New("Line parser " + name + " already exists")
+	}
+
+	if len(pc.Exec) > 0 {
+		if pc.Regex != "" || len(pc.Delimiters) > 0 || len(pc.Columns) > 0 || pc.CSV || pc.SQL || pc.JSON || len(pc.Mapping) > 0 || len(pc.Paths) > 0 {
+			return errors.New("Line parser " + name + ": exec cannot be combined with regex, delimiters, columns, csv, sql, json, mapping or paths")
+		}
+		p, err := newExecParser(name, pc.Exec, pc.Format, pc.Batch, pc.Source)
+		if err != nil {
+			return errors.New("Line parser " + name + ": " + err.Error())
+		}
+		execParsers[name] = p
+		return nil
+	}
+
+	if pc.JSON {
+		if pc.CSV || pc.SQL || pc.Regex != "" || len(pc.Delimiters) > 0 || len(pc.Columns) > 0 || len(pc.Mapping) > 0 {
+			return errors.New("Line parser " + name + ": json cannot be combined with csv, sql, regex, delimiters, columns or mapping")
+		}
+		newParser, err := newJSONParser(pc.Paths, pc.Source)
+		if err != nil {
+			return errors.New("Line parser " + name + ": " + err.Error())
+		}
+		streamParsers[name] = newParser
+		return nil
+	} else if len(pc.Paths) > 0 {
+		return errors.New("Line parser " + name + ": paths can only be used with json")
+	}
+
+	if pc.SQL {
+		if pc.CSV || pc.Regex != "" || len(pc.Delimiters) > 0 {
+			return errors.New("Line parser " + name + ": sql cannot be combined with csv, regex or delimiters")
+		}
+		newParser, err := newSQLParser(pc.Columns, pc.Mapping, pc.Tables, pc.Source)
+		if err != nil {
+			return errors.New("Line parser " + name + ": " + err.Error())
+		}
+		streamParsers[name] = newParser
+		return nil
+	} else if len(pc.Tables) > 0 {
+		return errors.New("Line parser " + name + ": tables can only be used with sql")
+	}
+
+	if pc.CSV {
+		if pc.Regex != "" {
+			return errors.New("Line parser " + name + ": csv cannot be combined with regex")
+		}
+		newParser, err := newCSVParser(pc.Delimiters, pc.Columns, pc.Mapping, pc.Source)
+		if err != nil {
+			return errors.New("Line parser " + name + ": " + err.Error())
+		}
+		streamParsers[name] = newParser
+		return nil
+	} else if len(pc.Mapping) > 0 {
+		return errors.New("Line parser " + name + ": mapping can only be used with csv or sql")
+	}
+
+	var parser func(line, source string) (Record, error)
+	var err error
+	if pc.Regex != "" {
+		if len(pc.Delimiters) > 0 || len(pc.Columns) > 0 {
+			return errors.New("Line parser " + name + ": regex cannot be combined with delimiters or columns")
+		}
+		parser, err = newRegexParser(pc.Regex)
+	} else {
+		parser, err = newSplitParser(pc.Delimiters, pc.Columns)
+	}
+	if err != nil {
+		return errors.New("Line parser " + name + ": " + err.Error())
+	}
+
+	lineParsers[name] = func(line, source string) (Record, error) {
+		if pc.Source != "" {
+			source = pc.Source
+		}
+		return parser(line, source)
+	}
+	return nil
+}
+
+// validField checks that a column or regex group name is a Record field, login or skip
+func validField(name string) bool {
+	switch strings.ToLower(name) {
+	case fieldLogin, fieldSkip, "username", "email", "emailrev", "hash", "password", "extra":
+		return true
+	}
+	return false
+}
+
+// setField sets the Record field with the column or regex group name
+func setField(r *Record, name, value string) {
+	switch strings.ToLower(name) {
+	case fieldLogin:
+		setLogin(r, value)
+	case "username":
+		r.Username = value
+	case "email":
+		r.Email = value
+	case "emailrev":
+		r.EmailRev = value
+	case "hash":
+		r.Hash = value
+	case "password":
+		r.Password = value
+	case "extra":
+		r.Extra = value
+	}
+}
+
+func newSplitParser(delimiters, columns []string) (func(line, source string) (Record, error), error) {
+	if len(delimiters) == 0 {
+		return nil, errors.New("either regex or delimiters must be set")
+	}
+	for _, d := range delimiters {
+		if d == "" {
+			return nil, errors.New("delimiters must not be empty")
+		}
+	}
+	if len(columns) < 2 {
+		return nil, errors.New("at least two columns must be set")
+	}
+	for _, col := range columns {
+		if !validField(col) {
+			return nil, errors.New("unknown column '" + col + "'")
+		}
+	}
+
+	return func(line, source string) (Record, error) {
+		result := Record{}
+
+		// try each delimiter until one gives enough columns
+		var r []string
+		for _, d := range delimiters {
+			r = strings.SplitN(line, d, len(columns))
+			if len(r) == len(columns) {
+				break
+			}
+		}
+		if len(r) != len(columns) {
+			return result, errors.New("Incorrect number of columns")
+		}
+
+		for i, col := range columns {
+			setField(&result, col, r[i])
+		}
+		result.Source = source
+		return result, nil
+	}, nil
+}
+
+func newRegexParser(pattern string) (func(line, source string) (Record, error), error) {
+	format, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	names := format.SubexpNames()
+	named := 0
+	for _, name := range names[1:] {
+		if name == "" {
+			continue
+		}
+		if !validField(name) {
+			return nil, errors.New("unknown named group '" + name + "'")
+		}
+		named++
+	}
+	if named == 0 {
+		return nil, errors.New("regex must have at least one named group")
+	}
+
+	return func(line, source string) (Record, error) {
+		result := Record{}
+
+		match := format.FindStringSubmatch(line)
+		if match == nil {
+			return result, errors.New("Regex match failed")
+		}
+
+		for i, name := range names {
+			if name != "" {
+				setField(&result, name, match[i])
+			}
+		}
+		result.Source = source
+		return result, nil
+	}, nil
+}
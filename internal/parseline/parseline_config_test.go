@@ -0,0 +1,88 @@
+package parseline
+
+import "testing"
+
+func TestRegisterSplit(t *testing.T) {
+	err := Register("test_split", ParserConfig{
+		Delimiters: []string{";", ":"},
+		Columns:    []string{"login", "password"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		line string
+		want Record
+	}{
+		{"user@example.com:pa:ss", Record{Email: "user@example.com", Password: "pa:ss", Source: "src"}},
+		{"someone;pass", Record{Username: "someone", Password: "pass", Source: "src"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseLine("test_split", tt.line, "src")
+		if err != nil {
+			t.Errorf("ParseLine(%q) error: %v", tt.line, err)
+		} else if got != tt.want {
+			t.Errorf("ParseLine(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+
+	if _, err := ParseLine("test_split", "no delimiter", "src"); err == nil {
+		t.Error("expected an error for a line without a delimiter")
+	}
+}
+
+func TestRegisterRegex(t *testing.T) {
+	err := Register("test_regex", ParserConfig{
+		Regex:  `^(?P<username>[^|]+)\|(?P<hash>[0-9a-f]+)\|(?P<extra>.*)$`,
+		Source: "fixed",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseLine("test_regex", "bob|abc123|hint", "src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Record{Username: "bob", Hash: "abc123", Extra: "hint", Source: "fixed"}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestRegisterInvalid(t *testing.T) {
+	invalid := map[string]ParserConfig{
+		"collections":   {Delimiters: []string{":"}, Columns: []string{"email", "password"}},
+		"no_delimiters": {Columns: []string{"email", "password"}},
+		"bad_column":    {Delimiters: []string{":"}, Columns: []string{"email", "phone"}},
+		"bad_regex":     {Regex: `(?P<email>`},
+		"no_groups":     {Regex: `.+:.+`},
+		"both":          {Regex: `(?P<email>.+)`, Delimiters: []string{":"}},
+	}
+	for name, pc := range invalid {
+		if err := Register(name, pc); err == nil {
+			t.Errorf("Register(%q) succeeded, want an error", name)
+		}
+	}
+}
+
+func TestRegisterMixedCase(t *testing.T) {
+	err := Register("Test_MixedCase", ParserConfig{Delimiters: []string{":"}, Columns: []string{"login", "password"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the config file lowercases the names, so every spelling finds the parser
+	for _, name := range []string{"Test_MixedCase", "test_mixedcase", "TEST_MIXEDCASE"} {
+		if !ParserExists(name) || !IsLineParser(name) {
+			t.Errorf("line parser %s does not exist", name)
+		}
+		if _, err := Stream(name); err != nil {
+			t.Errorf("Stream(%s): %v", name, err)
+		}
+	}
+	if err := Register("test_mixedCASE", ParserConfig{Delimiters: []string{":"}, Columns: []string{"login", "password"}}); err == nil {
+		t.Error("expected an error for a name that only differs in case")
+	}
+}
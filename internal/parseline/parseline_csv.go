@@ -0,0 +1,332 @@
+package parseline
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxCSVRecordLines is the number of lines a quoted field may span before it is treated as unterminated
+const maxCSVRecordLines = 100
+
+// csvDelimiters are tried when the delimiter of a CSV file is not configured
+var csvDelimiters = []rune{',', ';', '\t', '|'}
+
+// columnSynonyms maps normalised CSV header and SQL column names to Record fields
+var columnSynonyms = map[string]string{
+	"email":        "email",
+	"emailaddress": "email",
+	"mail":         "email",
+	"useremail":    "email",
+
+	"username":   "username",
+	"user":       "username",
+	"userid":     "username",
+	"login":      "username",
+	"userlogin":  "username",
+	"nick":       "username",
+	"nickname":   "username",
+	"screenname": "username",
+	"account":    "username",
+	"handle":     "username",
+
+	"password":      "password",
+	"pass":          "password",
+	"passwd":        "password",
+	"pwd":           "password",
+	"plaintext":     "password",
+	"plainpassword": "password",
+	"cleartext":     "password",
+
+	"hash":              "hash",
+	"passwordhash":      "hash",
+	"passhash":          "hash",
+	"pwdhash":           "hash",
+	"hashedpassword":    "hash",
+	"encryptedpassword": "hash",
+	"cryptedpassword":   "hash",
+	"passworddigest":    "hash",
+	"md5":               "hash",
+	"sha1":              "hash",
+	"sha256":            "hash",
+	"bcrypt":            "hash",
+}
+
+func init() {
+	streamParsers["csv"] = func() StreamParser {
+		return &csvParser{}
+	}
+}
+
+// csvParser parses RFC 4180 CSV files. The header row maps columns to fields by name, and unmapped columns are stored in Extra
+type csvParser struct {
+	// delimiter is the configured delimiter. If it is 0, the delimiter is detected from the first row of each file
+	delimiter rune
+	// mapping maps normalised header names to fields, before the synonyms
+	mapping map[string]string
+	// columns are the fields of each column when the file has no header row
+	columns []string
+	source  string
+
+	comma  rune
+	fields []string
+	names  []string
+	lines  []string
+	// quoted is true while a quoted field continues on the next line
+	quoted     bool
+	lastLine   int64
+	lastSource string
+	lastEmit   Emit
+}
+
+// newCSVParser validates the config of a CSV line parser
+func newCSVParser(delimiters, columns []string, mapping map[string]string, source string) (func() StreamParser, error) {
+	var delimiter rune
+	if len(delimiters) > 1 {
+		return nil, errors.New("csv accepts a single delimiter")
+	} else if len(delimiters) == 1 {
+		r := []rune(delimiters[0])
+		if len(r) != 1 || r[0] == '"' || r[0] == '\r' || r[0] == '\n' {
+			return nil, errors.New("csv delimiter must be a single character, other than a quote or newline")
+		}
+		delimiter = r[0]
+	}
+
+	for _, col := range columns {
+		if !validField(col) {
+			return nil, errors.New("unknown column '" + col + "'")
+		}
+	}
+
+	normalised := make(map[string]string, len(mapping))
+	for header, field := range mapping {
+		if !validField(field) {
+			return nil, errors.New("unknown field '" + field + "' for header '" + header + "'")
+		}
+		normalised[normaliseHeader(header)] = strings.ToLower(field)
+	}
+
+	return func() StreamParser {
+		return &csvParser{delimiter: delimiter, mapping: normalised, columns: columns, source: source}
+	}, nil
+}
+
+// normaliseHeader lowercases a header name and removes everything that isn't a letter or digit, e.g. Password_Hash becomes passwordhash
+func normaliseHeader(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// detectDelimiter returns the delimiter that occurs most often in the line
+func detectDelimiter(line string) rune {
+	best, bestCount := csvDelimiters[0], 0
+	for _, d := range csvDelimiters {
+		if n := strings.Count(line, string(d)); n > bestCount {
+			best, bestCount = d, n
+		}
+	}
+	return best
+}
+
+// joinExtra packs names and values into Extra as space separated name=value pairs, quoting values where needed
+func joinExtra(names, values []string) string {
+	var arr []string
+	for i, name := range names {
+		v := values[i]
+		if v == "" {
+			continue
+		}
+		if strings.ContainsAny(v, " \t\r\n\"=") {
+			v = strconv.Quote(v)
+		}
+		arr = append(arr, name+"="+v)
+	}
+	return strings.Join(arr, " ")
+}
+
+// columnField returns the field of a normalised column name, from the mapping or the synonyms. It is empty if the column has no field
+func columnField(mapping map[string]string, name string) string {
+	if field, ok := mapping[name]; ok {
+		return field
+	}
+	return columnSynonyms[name]
+}
+
+// mapColumns sets the fields of a Record from the values of a row. Columns without a field are packed into Extra
+func mapColumns(fields, names, values []string) Record {
+	result := Record{}
+	var extraNames, extraValues []string
+	for i, field := range fields {
+		switch field {
+		case fieldSkip:
+		case "":
+			extraNames = append(extraNames, names[i])
+			extraValues = append(extraValues, values[i])
+		default:
+			setField(&result, field, values[i])
+		}
+	}
+	if extra := joinExtra(extraNames, extraValues); extra != "" {
+		if result.Extra != "" {
+			extra = result.Extra + " " + extra
+		}
+		result.Extra = extra
+	}
+	return result
+}
+
+func (p *csvParser) Parse(line, source string, lineNum int64, emit Emit) error {
+	if p.comma == 0 {
+		p.comma = p.delimiter
+		if p.comma == 0 {
+			p.comma = detectDelimiter(line)
+		}
+	}
+
+	// a quoted field can contain newlines, so wait until it is closed
+	p.lastEmit, p.lastLine, p.lastSource = emit, lineNum, source
+	p.lines = append(p.lines, line)
+	p.quoted = quoteContinues(line, p.comma, p.quoted)
+	if p.quoted {
+		if len(p.lines) >= maxCSVRecordLines {
+			p.failUnterminated()
+		}
+		return nil
+	}
+	text := strings.Join(p.lines, "\n")
+	p.lines = p.lines[:0]
+
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = p.comma
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	values, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		emit(text, lineNum, Record{}, errors.New("Invalid CSV: "+err.Error()))
+		return nil
+	}
+
+	if p.fields == nil && !p.readHeader(values) {
+		if p.fields == nil {
+			emit(text, lineNum, Record{}, errors.New("No header row with known column names"))
+		}
+		return nil
+	}
+	if len(values) != len(p.fields) {
+		emit(text, lineNum, Record{}, errors.New("Incorrect number of columns"))
+		return nil
+	}
+
+	result := mapColumns(p.fields, p.names, values)
+	result.Source = source
+	if p.source != "" {
+		result.Source = p.source
+	}
+	emit(text, lineNum, result, nil)
+	return nil
+}
+
+// readHeader maps the columns from the first row. It returns true if the row contains a record instead of a header
+func (p *csvParser) readHeader(values []string) bool {
+	fields := make([]string, len(values))
+	names := make([]string, len(values))
+	known := 0
+	for i, v := range values {
+		// a header never contains an email address
+		if emailPattern.MatchString(strings.TrimSpace(v)) {
+			known = 0
+			break
+		}
+
+		names[i] = normaliseHeader(v)
+		if names[i] == "" {
+			names[i] = "column" + strconv.Itoa(i+1)
+		}
+		field := columnField(p.mapping, names[i])
+		fields[i] = field
+		if field != "" {
+			known++
+		}
+	}
+
+	if known > 0 {
+		p.fields, p.names = fields, names
+		return false
+	}
+	if len(p.columns) > 0 {
+		p.setColumns()
+		return true
+	}
+	return false
+}
+
+// setColumns uses the configured columns for a file without a header row
+func (p *csvParser) setColumns() {
+	p.fields = make([]string, len(p.columns))
+	p.names = make([]string, len(p.columns))
+	for i, col := range p.columns {
+		p.fields[i] = strings.ToLower(col)
+		p.names[i] = "column" + strconv.Itoa(i+1)
+	}
+}
+
+// Flush fails a record that is still waiting for a closing quote, then starts the next file
+func (p *csvParser) Flush() error {
+	for len(p.lines) > 0 && p.lastEmit != nil {
+		p.failUnterminated()
+	}
+	*p = csvParser{delimiter: p.delimiter, mapping: p.mapping, columns: p.columns, source: p.source}
+	return nil
+}
+
+// failUnterminated fails the line whose quoted field is never closed, and parses the lines after it again on their own,
+// so that one stray quote doesn't lose the records that follow it
+func (p *csvParser) failUnterminated() {
+	rest := append([]string(nil), p.lines[1:]...)
+	first := p.lastLine - int64(len(rest))
+	emit, source := p.lastEmit, p.lastSource
+	emit(p.lines[0], first, Record{}, errors.New("Unterminated quoted field"))
+
+	p.lines, p.quoted = p.lines[:0], false
+	for i, line := range rest {
+		p.Parse(line, source, first+1+int64(i), emit)
+	}
+}
+
+// quoteContinues reports whether a quoted field is still open at the end of the line, when `quoted` is whether one
+// was open at its start. Like encoding/csv with LazyQuotes, only a quote at the start of a field opens a quoted field,
+// and a quote inside it that isn't doubled or followed by a delimiter is part of the value
+func quoteContinues(line string, comma rune, quoted bool) bool {
+	delimiter := string(comma)
+	fieldStart := !quoted
+	for i := 0; i < len(line); i++ {
+		rest := line[i:]
+		switch {
+		case quoted && strings.HasPrefix(rest, `""`):
+			// an escaped quote
+			i++
+		case quoted && rest[0] == '"':
+			if len(rest) == 1 || strings.HasPrefix(rest[1:], delimiter) {
+				quoted = false
+			}
+		case quoted:
+		case strings.HasPrefix(rest, delimiter):
+			i += len(delimiter) - 1
+			fieldStart = true
+			continue
+		case fieldStart && rest[0] == '"':
+			quoted = true
+		}
+		fieldStart = false
+	}
+	return quoted
+}
@@ -0,0 +1,160 @@
+package parseline
+
+import "testing"
+
+// streamLines parses the lines with a stream parser, returning the records and the number of errors
+func streamLines(t *testing.T, name string, lines []string) ([]Record, int) {
+	p, err := Stream(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []Record
+	var errCount int
+	emit := func(_ string, _ int64, r Record, err error) {
+		if err != nil {
+			errCount++
+			return
+		}
+		records = append(records, r)
+	}
+	for i, line := range lines {
+		if err := p.Parse(line, "src", int64(i+1), emit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return records, errCount
+}
+
+func TestCSV(t *testing.T) {
+	lines := []string{
+		`Email,Password_Hash,User Name,IP`,
+		`user@example.com,5f4dcc3b5aa765d61d8327deb882cf99,bob,1.2.3.4`,
+		`"quoted@example.com","$2y$10$abc","Smith, ""Al""","line one`,
+		`line two"`,
+		`too,few`,
+	}
+	records, errCount := streamLines(t, "csv", lines)
+
+	want := []Record{
+		{Source: "src", Email: "user@example.com", Hash: "5f4dcc3b5aa765d61d8327deb882cf99", Username: "bob", Extra: "ip=1.2.3.4"},
+		{Source: "src", Email: "quoted@example.com", Hash: "$2y$10$abc", Username: `Smith, "Al"`, Extra: `ip="line one\nline two"`},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d: %+v", len(records), len(want), records)
+	}
+	for i := range want {
+		if records[i] != want[i] {
+			t.Errorf("record %d = %+v, want %+v", i, records[i], want[i])
+		}
+	}
+	if errCount != 1 {
+		t.Errorf("got %d errors, want 1", errCount)
+	}
+
+	if name, _ := Detect(lines[:3]); name != "csv" {
+		t.Errorf("Detect = %s, want csv", name)
+	}
+}
+
+func TestRegisterCSV(t *testing.T) {
+	err := Register("test_csv", ParserConfig{
+		CSV:        true,
+		Delimiters: []string{";"},
+		Columns:    []string{"login", "-", "password"},
+		Mapping:    map[string]string{"Kennwort": "password", "Benutzer": "login"},
+		Source:     "fixed",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, _ := streamLines(t, "test_csv", []string{"Benutzer;Kennwort;Notiz", "bob;hunter2;hi there"})
+	want := Record{Source: "fixed", Username: "bob", Password: "hunter2", Extra: `notiz="hi there"`}
+	if len(records) != 1 || records[0] != want {
+		t.Errorf("with header got %+v, want %+v", records, want)
+	}
+
+	records, _ = streamLines(t, "test_csv", []string{"a@b.com;x;pw"})
+	want = Record{Source: "fixed", Email: "a@b.com", Password: "pw"}
+	if len(records) != 1 || records[0] != want {
+		t.Errorf("without header got %+v, want %+v", records, want)
+	}
+
+	if err := Register("test_csv_bad", ParserConfig{CSV: true, Delimiters: []string{"::"}}); err == nil {
+		t.Error("expected an error for a multi-character csv delimiter")
+	}
+}
+
+func TestCSVFieldSeparators(t *testing.T) {
+	lines := []string{
+		`email,password`,
+		`multi@example.com,"first`,
+		`second"`,
+		"tab@example.com,\"a\tb\"",
+		`plain@example.com,pw`,
+	}
+	records, errCount := streamLines(t, "csv", lines)
+	if errCount != 0 || len(records) != 3 {
+		t.Fatalf("got %d records and %d errors, want 3 records", len(records), errCount)
+	}
+
+	if records[0].Password != "first\nsecond" || records[0].Check() != ErrFieldSeparator {
+		t.Errorf("a quoted newline must fail Check, got %+v", records[0])
+	}
+	if records[1].Check() != ErrFieldSeparator {
+		t.Errorf("a quoted tab must fail Check, got %+v", records[1])
+	}
+	if err := records[2].Check(); err != nil {
+		t.Errorf("Check(%+v) = %v", records[2], err)
+	}
+}
+
+func TestCSVBareQuotes(t *testing.T) {
+	// quotes inside unquoted fields don't start a multi-line field
+	lines := []string{
+		`email,password`,
+		`a@x.com,pa"ss`,
+		`b@x.com,ok`,
+		`c@x.com,q"q`,
+		`d@x.com,fine`,
+		`e@x.com,"say ""hi"""`,
+	}
+	records, errCount := streamLines(t, "csv", lines)
+	want := []string{`pa"ss`, "ok", `q"q`, "fine", `say "hi"`}
+	if errCount != 0 || len(records) != len(want) {
+		t.Fatalf("got %d records and %d errors, want %d records: %+v", len(records), errCount, len(want), records)
+	}
+	for i, pw := range want {
+		if records[i].Password != pw {
+			t.Errorf("record %d password = %q, want %q", i, records[i].Password, pw)
+		}
+	}
+
+	// a quoted field that is never closed only fails its own line
+	p, err := Stream("csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lineNums []int64
+	var errLines []int64
+	emit := func(_ string, lineNum int64, r Record, err error) {
+		lineNums = append(lineNums, lineNum)
+		if err != nil {
+			errLines = append(errLines, lineNum)
+		}
+	}
+	for i, line := range []string{`email,password`, `f@x.com,"open`, `g@x.com,ok`, `h@x.com,ok`} {
+		if err := p.Parse(line, "src", int64(i+1), emit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(lineNums) != 3 || lineNums[0] != 2 || lineNums[1] != 3 || lineNums[2] != 4 || len(errLines) != 1 || errLines[0] != 2 {
+		t.Errorf("got results for lines %v and errors for %v, want 2 3 4 and an error for 2", lineNums, errLines)
+	}
+}
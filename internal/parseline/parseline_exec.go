@@ -0,0 +1,314 @@
+package parseline
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// exec parser output formats
+const (
+	FormatJSONL = "jsonl"
+	FormatTSV   = "tsv"
+)
+
+// defaultExecBatch is the number of lines written to an external line parser before its stdin is flushed
+const defaultExecBatch = 1000
+
+// maxExecOutputLength is the maximum length of a line of output from an external line parser
+const maxExecOutputLength = 64 * 1024 * 1024
+
+var execParsers = make(map[string]*execParser)
+
+// execItem is a line that has been written to an external line parser and is waiting for its result
+type execItem struct {
+	line    string
+	source  string
+	lineNum int64
+	emit    Emit
+}
+
+// execResult is the result of an execItem, which is emitted by the goroutine that calls Parse or Flush
+type execResult struct {
+	item execItem
+	r    Record
+	err  error
+}
+
+// execRecord is a line of JSONL output from an external line parser
+type execRecord struct {
+	Source   string `json:"source"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	EmailRev string `json:"emailRev"`
+	Hash     string `json:"hash"`
+	Password string `json:"password"`
+	Extra    string `json:"extra"`
+	Error    string `json:"error"`
+}
+
+// execParser streams lines to a long-running subprocess, which writes one result line for each input line
+type execParser struct {
+	name   string
+	args   []string
+	format string
+	batch  int
+	source string
+
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	w         *bufio.Writer
+	unflushed int
+	// pending are the lines that the subprocess hasn't answered yet, in order
+	pending chan execItem
+	// results are the answered lines that haven't been emitted yet
+	results chan execResult
+	// inFlight is the number of lines that are pending or waiting in results. It is only used by the caller's goroutine
+	inFlight int
+	readDone chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func newExecParser(name string, args []string, format string, batch int, source string) (*execParser, error) {
+	if len(args) == 0 || args[0] == "" {
+		return nil, errors.New("exec must contain the command to run")
+	}
+
+	format = strings.ToLower(format)
+	if format == "" {
+		format = FormatJSONL
+	}
+	if format != FormatJSONL && format != FormatTSV {
+		return nil, errors.New("unknown format '" + format + "', must be " + FormatJSONL + " or " + FormatTSV)
+	}
+
+	if batch < 0 {
+		return nil, errors.New("batch must be greater than or equal to 0")
+	} else if batch == 0 {
+		batch = defaultExecBatch
+	}
+
+	return &execParser{name: name, args: args, format: format, batch: batch, source: source}, nil
+}
+
+// start runs the subprocess. It is started when the first line is parsed
+func (p *execParser) start() error {
+	p.cmd = exec.Command(p.args[0], p.args[1:]...)
+	p.cmd.Stderr = os.Stderr
+	p.cmd.SysProcAttr = sysProcAttr()
+
+	var err error
+	p.stdin, err = p.cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = p.cmd.Start(); err != nil {
+		p.cmd = nil
+		return errors.New("Starting line parser " + p.name + ": " + err.Error())
+	}
+
+	p.w = bufio.NewWriter(p.stdin)
+	// allow a few batches to be in flight so that the subprocess is never waiting for input. Parse keeps inFlight below
+	// the capacity, so read never blocks on results
+	p.pending = make(chan execItem, 4*p.batch)
+	p.results = make(chan execResult, 4*p.batch)
+	p.readDone = make(chan struct{})
+	go p.read(stdout)
+	return nil
+}
+
+func (p *execParser) setErr(err error) {
+	p.mu.Lock()
+	if p.err == nil {
+		p.err = err
+	}
+	p.mu.Unlock()
+}
+
+func (p *execParser) getErr() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// read matches each line of output to the oldest pending line, and queues the result for the caller to emit
+func (p *execParser) read(stdout io.Reader) {
+	defer close(p.readDone)
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxExecOutputLength)
+	for scanner.Scan() {
+		var item execItem
+		select {
+		case item = <-p.pending:
+		default:
+			p.setErr(errors.New("Line parser " + p.name + " wrote more lines than it was given"))
+			p.drain()
+			return
+		}
+
+		r, err := p.decode(scanner.Text())
+		if p.source != "" {
+			r.Source = p.source
+		} else if r.Source == "" {
+			r.Source = item.source
+		}
+		p.results <- execResult{item: item, r: r, err: err}
+	}
+
+	err := scanner.Err()
+	if err == nil {
+		err = errors.New("Line parser " + p.name + " exited")
+	}
+	p.setErr(err)
+	p.drain()
+}
+
+// drain fails every pending line after the subprocess has stopped responding
+func (p *execParser) drain() {
+	err := p.getErr()
+	for item := range p.pending {
+		p.results <- execResult{item: item, err: err}
+	}
+}
+
+// emitResult waits for the oldest line in flight to be answered, then emits it
+func (p *execParser) emitResult() {
+	res := <-p.results
+	p.inFlight--
+	res.item.emit(res.item.line, res.item.lineNum, res.r, res.err)
+}
+
+// emitReady emits the lines that have already been answered, without waiting
+func (p *execParser) emitReady() {
+	for {
+		select {
+		case res := <-p.results:
+			p.inFlight--
+			res.item.emit(res.item.line, res.item.lineNum, res.r, res.err)
+		default:
+			return
+		}
+	}
+}
+
+// decode converts a line of output into a Record
+func (p *execParser) decode(s string) (Record, error) {
+	if p.format == FormatTSV {
+		if strings.HasPrefix(s, "!") {
+			return Record{}, errors.New(s[1:])
+		}
+		cols := strings.Split(s, "\t")
+		if len(cols) != 6 {
+			return Record{}, errors.New("Line parser " + p.name + " wrote an incorrect number of columns")
+		}
+		return Record{Source: cols[0], Username: cols[1], Email: cols[2], Hash: cols[3], Password: cols[4], Extra: cols[5]}, nil
+	}
+
+	var r execRecord
+	if err := json.Unmarshal([]byte(s), &r); err != nil {
+		return Record{}, errors.New("Line parser " + p.name + " wrote invalid JSON: " + err.Error())
+	}
+	if r.Error != "" {
+		return Record{}, errors.New(r.Error)
+	}
+	return Record{Source: r.Source, Username: r.Username, Email: r.Email, EmailRev: r.EmailRev, Hash: r.Hash, Password: r.Password, Extra: r.Extra}, nil
+}
+
+// Parse writes the line to the subprocess, and emits the results that are ready. It blocks while too many lines are
+// waiting for their results. Results are always emitted on the goroutine that calls Parse or Flush
+func (p *execParser) Parse(line, source string, lineNum int64, emit Emit) error {
+	if p.cmd == nil {
+		if err := p.start(); err != nil {
+			return err
+		}
+	}
+	if err := p.getErr(); err != nil {
+		return err
+	}
+
+	p.emitReady()
+	if p.inFlight >= cap(p.pending) {
+		// the queue is full, make sure the subprocess has every queued line before waiting for it
+		if err := p.flushInput(); err != nil {
+			return err
+		}
+		p.emitResult()
+	}
+	p.pending <- execItem{line: line, source: source, lineNum: lineNum, emit: emit}
+	p.inFlight++
+
+	if _, err := p.w.WriteString(line + "\n"); err != nil {
+		return err
+	}
+	p.unflushed++
+	if p.unflushed >= p.batch {
+		return p.flushInput()
+	}
+	return nil
+}
+
+func (p *execParser) flushInput() error {
+	p.unflushed = 0
+	return p.w.Flush()
+}
+
+// Flush waits until the result of every line has been emitted
+func (p *execParser) Flush() error {
+	if p.cmd == nil {
+		return nil
+	}
+	err := p.flushInput()
+	for p.inFlight > 0 {
+		p.emitResult()
+	}
+	if e := p.getErr(); e != nil {
+		return e
+	}
+	return err
+}
+
+// Close waits for every result, then stops the subprocess
+func (p *execParser) Close() error {
+	if p.cmd == nil {
+		return nil
+	}
+	err := p.Flush()
+
+	p.stdin.Close()
+	close(p.pending)
+	<-p.readDone
+	if waitErr := p.cmd.Wait(); waitErr != nil && err == nil {
+		err = errors.New("Line parser " + p.name + ": " + waitErr.Error())
+	}
+	p.cmd = nil
+	p.err = nil
+	return err
+}
+
+// parseLine parses a single line synchronously, for callers that need the result immediately
+func (p *execParser) parseLine(line, source string) (Record, error) {
+	var result Record
+	var resultErr error
+	err := p.Parse(line, source, 0, func(_ string, _ int64, r Record, err error) {
+		result, resultErr = r, err
+	})
+	if err == nil {
+		err = p.Flush()
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	return result, resultErr
+}
@@ -0,0 +1,138 @@
+package parseline
+
+import (
+	"os/exec"
+	"strconv"
+	"testing"
+)
+
+func TestExecParser(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	// usernames are echoed back as TSV, lines starting with # are errors
+	script := `while IFS= read -r line; do case "$line" in "#"*) echo "!comment";; *) printf '\t%s\t\t\t\t\n' "$line";; esac; done`
+	err := Register("test_exec", ParserConfig{Exec: []string{"sh", "-c", script}, Format: FormatTSV, Batch: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Stream("test_exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// more lines than the queue holds, to exercise back-pressure
+	const n = 50
+	var got []int64
+	var errCount int
+	emit := func(line string, lineNum int64, r Record, err error) {
+		got = append(got, lineNum)
+		if err != nil {
+			errCount++
+		} else if r.Username != line || r.Source != "src" {
+			t.Errorf("line %d: got %+v", lineNum, r)
+		}
+	}
+	for i := int64(1); i <= n; i++ {
+		line := "user" + strconv.FormatInt(i, 10)
+		if i%10 == 0 {
+			line = "#" + line
+		}
+		if err := p.Parse(line, "src", i, emit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != n {
+		t.Fatalf("got %d results, want %d", len(got), n)
+	}
+	for i, lineNum := range got {
+		if lineNum != int64(i+1) {
+			t.Fatalf("result %d is for line %d, results must be in order", i, lineNum)
+		}
+	}
+	if errCount != n/10 {
+		t.Errorf("got %d errors, want %d", errCount, n/10)
+	}
+
+	// the synchronous interface works on the same subprocess
+	r, err := ParseLine("test_exec", "alice", "src")
+	if err != nil || r.Username != "alice" {
+		t.Errorf("ParseLine = %+v, %v", r, err)
+	}
+
+	if err := CloseAll(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestExecParserEmitsOnCaller checks that results are emitted on the goroutine that calls Parse and Flush, so emit can
+// share unlocked state with the scan loop. Run with -race
+func TestExecParserEmitsOnCaller(t *testing.T) {
+	if _, err := exec.LookPath("cat"); err != nil {
+		t.Skip("cat is not available")
+	}
+
+	err := Register("test_exec_cat", ParserConfig{Exec: []string{"cat"}, Format: FormatTSV, Batch: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Stream("test_exec_cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseAll()
+
+	// written by both the scan loop and emit, like the output file and checkpoint in cmd
+	counts := make(map[string]int)
+	var emitted int64
+	emit := func(line string, lineNum int64, r Record, err error) {
+		if err != nil {
+			t.Errorf("line %d: %v", lineNum, err)
+			return
+		}
+		counts[r.Username]++
+		emitted = lineNum
+	}
+
+	const n = 200
+	for i := int64(1); i <= n; i++ {
+		counts["scanned"]++
+		line := "src\tuser" + strconv.FormatInt(i%7, 10) + "\t\t\tpass\t"
+		if err := p.Parse(line, "", i, emit); err != nil {
+			t.Fatal(err)
+		}
+		if emitted > i {
+			t.Fatalf("line %d was emitted before it was parsed", emitted)
+		}
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if emitted != n || counts["scanned"] != n {
+		t.Errorf("emitted up to line %d of %d", emitted, n)
+	}
+	if counts["user1"] != 29 {
+		t.Errorf("got %d records of user1, want 29", counts["user1"])
+	}
+}
+
+func TestExecParsersAreNotDetected(t *testing.T) {
+	err := Register("test_exec_hidden", ParserConfig{Exec: []string{"does-not-exist-dumpdb"}, Format: FormatTSV})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range Parsers() {
+		if name == "test_exec_hidden" {
+			t.Error("exec parsers must not be used for auto detection")
+		}
+	}
+	if IsLineParser("test_exec_hidden") || !IsExecParser("test_exec_hidden") || !ParserExists("test_exec_hidden") {
+		t.Error("exec parsers must only be registered as exec parsers")
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package parseline
+
+import "syscall"
+
+// sysProcAttr runs external line parsers in their own process group, so that CTRL+C in the terminal
+// doesn't kill them before the lines that are in flight have been parsed
+func sysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
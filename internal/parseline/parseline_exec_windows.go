@@ -0,0 +1,8 @@
+package parseline
+
+import "syscall"
+
+// sysProcAttr returns the default process attributes, Windows doesn't have process groups like Unix
+func sysProcAttr() *syscall.SysProcAttr {
+	return nil
+}
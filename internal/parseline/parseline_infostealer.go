@@ -0,0 +1,112 @@
+package parseline
+
+import (
+	"errors"
+	"strings"
+)
+
+func init() {
+	streamParsers["infostealer"] = func() StreamParser {
+		return &infostealerParser{}
+	}
+}
+
+// infostealerParser parses infostealer logs, where each record is spread over several `Key: value` lines:
+//
+//	URL: https://example.com/login
+//	Username: user@example.com
+//	Password: hunter2
+//	===============
+//
+// A record ends at a separator line, or when a key that the record already has is repeated
+type infostealerParser struct {
+	record   Record
+	url      string
+	app      string
+	lines    []string
+	lastLine int64
+	hasLogin bool
+	lastEmit Emit
+}
+
+// infostealerKey maps the keys used by common stealers to the part of the record that they set
+func infostealerKey(key string) string {
+	switch strings.ToLower(strings.TrimSpace(key)) {
+	case "url", "host", "hostname", "site", "link":
+		return "url"
+	case "login", "username", "user", "user login", "email", "mail":
+		return "login"
+	case "password", "pass", "user password":
+		return "password"
+	case "soft", "application", "browser", "software", "app":
+		return "app"
+	}
+	return ""
+}
+
+// isSeparator checks for lines like `=====` or `-----` between records
+func isSeparator(line string) bool {
+	line = strings.TrimSpace(line)
+	return len(line) >= 3 && strings.Trim(line, "=-*_") == ""
+}
+
+func (p *infostealerParser) Parse(line, source string, lineNum int64, emit Emit) error {
+	p.lastEmit = emit
+	if isSeparator(line) {
+		p.emit(emit)
+		return nil
+	}
+
+	idx := strings.Index(line, ":")
+	if idx < 0 {
+		// stealers add banners and other noise between records
+		return nil
+	}
+	key, value := infostealerKey(line[:idx]), strings.TrimSpace(line[idx+1:])
+	if key == "" {
+		return nil
+	}
+
+	// a repeated key starts the next record
+	if (key == "url" && p.url != "") || (key == "login" && p.hasLogin) || (key == "password" && p.record.Password != "") || (key == "app" && p.app != "") {
+		p.emit(emit)
+	}
+
+	switch key {
+	case "url":
+		p.url = value
+	case "login":
+		setField(&p.record, fieldLogin, value)
+		p.hasLogin = true
+	case "password":
+		p.record.Password = value
+	case "app":
+		p.app = value
+	}
+	p.record.Source = source
+	p.lines = append(p.lines, line)
+	p.lastLine = lineNum
+	return nil
+}
+
+// emit sends the current record and starts the next one
+func (p *infostealerParser) emit(emit Emit) {
+	if len(p.lines) == 0 {
+		return
+	}
+
+	if !p.hasLogin || p.record.Password == "" {
+		emit(strings.Join(p.lines, "\n"), p.lastLine, Record{}, errors.New("Incomplete record"))
+	} else {
+		p.record.Extra = joinExtra([]string{"url", "app"}, []string{p.url, p.app})
+		emit(strings.Join(p.lines, "\n"), p.lastLine, p.record, nil)
+	}
+	*p = infostealerParser{lines: p.lines[:0], lastEmit: p.lastEmit}
+}
+
+func (p *infostealerParser) Flush() error {
+	if p.lastEmit != nil {
+		p.emit(p.lastEmit)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package parseline
+
+import "testing"
+
+func TestInfostealer(t *testing.T) {
+	lines := []string{
+		"*** Stealer banner ***",
+		"URL: https://example.com/login",
+		"Username: user@example.com",
+		"Password: hunter2",
+		"Application: Google Chrome",
+		"===============",
+		"URL: https://example.org/?next=/home",
+		"Login: bob",
+		"Password: letmein",
+		"URL: https://example.net",
+		"Login: no-password",
+	}
+
+	p, err := Stream("infostealer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []Record
+	var lineNums []int64
+	var errCount int
+	emit := func(_ string, lineNum int64, r Record, err error) {
+		lineNums = append(lineNums, lineNum)
+		if err != nil {
+			errCount++
+			return
+		}
+		records = append(records, r)
+	}
+	for i, line := range lines {
+		if err := p.Parse(line, "src", int64(i+1), emit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Record{
+		{Source: "src", Email: "user@example.com", Password: "hunter2", Extra: `url=https://example.com/login app="Google Chrome"`},
+		{Source: "src", Username: "bob", Password: "letmein", Extra: `url="https://example.org/?next=/home"`},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d: %+v", len(records), len(want), records)
+	}
+	for i := range want {
+		if records[i] != want[i] {
+			t.Errorf("record %d = %+v, want %+v", i, records[i], want[i])
+		}
+	}
+	if errCount != 1 {
+		t.Errorf("got %d incomplete records, want 1", errCount)
+	}
+	if wantNums := []int64{5, 9, 11}; len(lineNums) != 3 || lineNums[0] != wantNums[0] || lineNums[1] != wantNums[1] || lineNums[2] != wantNums[2] {
+		t.Errorf("records ended on lines %v, want %v", lineNums, wantNums)
+	}
+
+	if name, _ := Detect(lines); name != "infostealer" {
+		t.Errorf("Detect = %s, want infostealer", name)
+	}
+}
@@ -0,0 +1,224 @@
+package parseline
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxJSONObjectLength is the number of bytes a single JSON document may use before it is skipped
+const maxJSONObjectLength = 16 * 1024 * 1024
+
+func init() {
+	streamParsers["json"] = func() StreamParser {
+		return &jsonParser{}
+	}
+}
+
+// jsonParser parses JSON lines, and top-level JSON arrays of objects that may be spread over many lines.
+// Nested fields are flattened into dotted paths like user.email, which are mapped to fields by name
+type jsonParser struct {
+	// paths maps lowercased dotted paths to fields, before the synonyms of the last part of each path
+	paths  map[string]string
+	source string
+
+	// inArray is true inside a top-level array
+	inArray bool
+	depth   int
+	inStr   bool
+	esc     bool
+	buf     []byte
+	skip    bool
+
+	lastLine int64
+	lastEmit Emit
+}
+
+// newJSONParser validates the config of a JSON line parser
+func newJSONParser(paths []string, source string) (func() StreamParser, error) {
+	mapping := make(map[string]string, len(paths))
+	for _, s := range paths {
+		idx := strings.LastIndex(s, "=")
+		if idx <= 0 {
+			return nil, errors.New("path '" + s + "' must be in the format path=field")
+		}
+		field := strings.ToLower(s[idx+1:])
+		if !validField(field) {
+			return nil, errors.New("unknown field '" + field + "' for path '" + s[:idx] + "'")
+		}
+		mapping[strings.ToLower(s[:idx])] = field
+	}
+
+	return func() StreamParser {
+		return &jsonParser{paths: mapping, source: source}
+	}, nil
+}
+
+// flattenJSON adds the scalar values of a decoded JSON value to leaves, by dotted path. Array elements use their index
+func flattenJSON(prefix string, v interface{}, leaves map[string]string) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			flattenJSON(join(key), child, leaves)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flattenJSON(join(strconv.Itoa(i)), child, leaves)
+		}
+	case string:
+		leaves[prefix] = v
+	case json.Number:
+		leaves[prefix] = v.String()
+	case bool:
+		leaves[prefix] = strconv.FormatBool(v)
+	}
+}
+
+func (p *jsonParser) Parse(line, source string, lineNum int64, emit Emit) error {
+	p.lastEmit, p.lastLine = emit, lineNum
+
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+
+		// between documents
+		if p.depth == 0 {
+			switch ch {
+			case ' ', '\t', '\r', ',':
+			case '[':
+				if p.inArray {
+					emit(line, lineNum, Record{}, errors.New("Expected a JSON object"))
+					return nil
+				}
+				p.inArray = true
+			case ']':
+				p.inArray = false
+			case '{':
+				p.depth = 1
+				p.buf = append(p.buf[:0], ch)
+			default:
+				emit(line, lineNum, Record{}, errors.New("Expected a JSON object"))
+				return nil
+			}
+			continue
+		}
+
+		if !p.skip {
+			if len(p.buf) < maxJSONObjectLength {
+				p.buf = append(p.buf, ch)
+			} else {
+				emit(string(p.buf[:256])+"...", lineNum, Record{}, errors.New("JSON object is too long"))
+				p.skip, p.buf = true, p.buf[:0]
+			}
+		}
+
+		if p.inStr {
+			if p.esc {
+				p.esc = false
+			} else if ch == '\\' {
+				p.esc = true
+			} else if ch == '"' {
+				p.inStr = false
+			}
+			continue
+		}
+
+		switch ch {
+		case '"':
+			p.inStr = true
+		case '{', '[':
+			p.depth++
+		case '}', ']':
+			p.depth--
+			if p.depth == 0 {
+				if !p.skip {
+					p.emitObject(source, lineNum, emit)
+				}
+				p.skip = false
+			}
+		}
+	}
+
+	// newlines are only allowed between tokens, so they don't need to be kept
+	return nil
+}
+
+// emitObject decodes a complete JSON object and maps its fields to a record
+func (p *jsonParser) emitObject(source string, lineNum int64, emit Emit) {
+	raw := string(p.buf)
+
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.UseNumber()
+	var doc map[string]interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		emit(raw, lineNum, Record{}, errors.New("Invalid JSON: "+err.Error()))
+		return
+	}
+
+	leaves := make(map[string]string)
+	flattenJSON("", doc, leaves)
+	paths := make([]string, 0, len(leaves))
+	for path := range leaves {
+		paths = append(paths, path)
+	}
+	// shallow paths are mapped before deeper ones, then alphabetically
+	sort.Slice(paths, func(i, j int) bool {
+		di, dj := strings.Count(paths[i], "."), strings.Count(paths[j], ".")
+		if di != dj {
+			return di < dj
+		}
+		return paths[i] < paths[j]
+	})
+
+	// explicit paths take precedence over synonyms
+	fields := make([]string, len(paths))
+	used := make(map[string]bool)
+	for i, path := range paths {
+		if field, ok := p.paths[strings.ToLower(path)]; ok {
+			fields[i] = field
+			used[field] = true
+		}
+	}
+	for i, path := range paths {
+		if fields[i] != "" {
+			continue
+		}
+		name := path
+		if idx := strings.LastIndex(path, "."); idx >= 0 {
+			name = path[idx+1:]
+		}
+		field := columnSynonyms[normaliseHeader(name)]
+		if field != "" && !used[field] {
+			fields[i] = field
+			used[field] = true
+		}
+	}
+
+	values := make([]string, len(paths))
+	for i, path := range paths {
+		values[i] = leaves[path]
+	}
+	result := mapColumns(fields, paths, values)
+	result.Source = source
+	if p.source != "" {
+		result.Source = p.source
+	}
+	emit(raw, lineNum, result, nil)
+}
+
+// Flush fails a JSON object that was not finished by the end of the file, then starts the next file
+func (p *jsonParser) Flush() error {
+	if p.depth > 0 && !p.skip && p.lastEmit != nil {
+		p.lastEmit(string(p.buf), p.lastLine, Record{}, errors.New("Unterminated JSON object"))
+	}
+	*p = jsonParser{paths: p.paths, source: p.source}
+	return nil
+}
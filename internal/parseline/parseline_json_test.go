@@ -0,0 +1,81 @@
+package parseline
+
+import "testing"
+
+func TestJSON(t *testing.T) {
+	lines := []string{
+		`{"_id":{"$oid":"5f1"},"user":{"email":"a@example.com","name":"Al"},"password":"pw1"}`,
+		`[`,
+		`  {"login": "bob", "credentials": {"hash": "$2y$10$abc"},`,
+		`   "tags": ["x", "y z"], "age": 30, "deleted": null},`,
+		`  "not an object"`,
+		`]`,
+	}
+	records, errCount := streamLines(t, "json", lines)
+
+	want := []Record{
+		{Source: "src", Email: "a@example.com", Password: "pw1", Extra: "_id.$oid=5f1 user.name=Al"},
+		{Source: "src", Username: "bob", Hash: "$2y$10$abc", Extra: `age=30 tags.0=x tags.1="y z"`},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d: %+v", len(records), len(want), records)
+	}
+	for i := range want {
+		if records[i] != want[i] {
+			t.Errorf("record %d = %+v, want %+v", i, records[i], want[i])
+		}
+	}
+	if errCount != 1 {
+		t.Errorf("got %d errors, want 1", errCount)
+	}
+
+	if name, _ := Detect(lines[:1]); name != "json" {
+		t.Errorf("Detect = %s, want json", name)
+	}
+}
+
+func TestRegisterJSON(t *testing.T) {
+	err := Register("test_json", ParserConfig{
+		JSON:  true,
+		Paths: []string{"_source.contact.primary=email", "_source.secret=password", "_source.email=-"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseLine("test_json", `{"_source":{"contact":{"primary":"a@b.com"},"email":"old@b.com","secret":"pw"}}`, "src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Record{Source: "src", Email: "a@b.com", Password: "pw"}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if err := Register("test_json_bad", ParserConfig{JSON: true, Paths: []string{"user.email"}}); err == nil {
+		t.Error("expected an error for a path without a field")
+	}
+}
+
+func TestJSONFieldSeparators(t *testing.T) {
+	lines := []string{
+		`{"email":"a@example.com","password":"line\none"}`,
+		`{"email":"b@example.com","password":"tab\there","note":"kept\tquoted"}`,
+		`{"email":"c@example.com","password":"pw","note":"kept\tquoted"}`,
+	}
+	records, errCount := streamLines(t, "json", lines)
+	if errCount != 0 || len(records) != 3 {
+		t.Fatalf("got %d records and %d errors, want 3 records", len(records), errCount)
+	}
+
+	// decoded strings can contain separators, which Check rejects before they reach a batch file
+	for _, r := range records[:2] {
+		if r.Check() != ErrFieldSeparator {
+			t.Errorf("Check(%+v) must fail", r)
+		}
+	}
+	// values packed into Extra are quoted
+	if err := records[2].Check(); err != nil || records[2].Extra != `note="kept\tquoted"` {
+		t.Errorf("Check(%+v) = %v", records[2], err)
+	}
+}
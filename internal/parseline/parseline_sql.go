@@ -0,0 +1,524 @@
+package parseline
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxSQLStatementLength is the number of bytes of a statement that are kept to find its table and columns.
+// The values of INSERT statements are parsed as they are read, so they are not limited
+const maxSQLStatementLength = 1024 * 1024
+
+var (
+	sqlInsertPattern = regexp.MustCompile(`(?is)^\s*(?:INSERT|REPLACE)\b.*?\bINTO\s+(\S+?)\s*(?:\((.*)\))?\s*VALUES$`)
+	sqlCreatePattern = regexp.MustCompile(`(?is)^\s*CREATE\s+(?:TEMPORARY\s+)?TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?(\S+?)\s*\((.*)\)`)
+	sqlCopyPattern   = regexp.MustCompile(`(?is)^\s*COPY\s+(\S+?)\s*(?:\((.*?)\))?\s+FROM\s+stdin`)
+	// pg_dump turns off backslash escapes in strings
+	sqlStandardStringsPattern = regexp.MustCompile(`(?is)^\s*SET\s+standard_conforming_strings\s*=\s*'?on'?`)
+)
+
+// sqlConstraints are the keywords that start a table constraint instead of a column in CREATE TABLE
+var sqlConstraints = map[string]bool{
+	"PRIMARY": true, "KEY": true, "UNIQUE": true, "CONSTRAINT": true, "INDEX": true,
+	"FULLTEXT": true, "SPATIAL": true, "CHECK": true, "FOREIGN": true, "EXCLUDE": true,
+}
+
+func init() {
+	streamParsers["sql"] = func() StreamParser {
+		return &sqlParser{backslash: true}
+	}
+}
+
+// sqlParser parses the rows of INSERT statements and COPY blocks in mysqldump and pg_dump files.
+// Columns are mapped to fields by name, from the INSERT column list or the CREATE TABLE statement of the table
+type sqlParser struct {
+	// mapping maps normalised column names to fields, before the synonyms
+	mapping map[string]string
+	// columns are the fields of each column of tables without a CREATE TABLE statement or column list
+	columns []string
+	// tables are the normalised names of the tables to read. Every table with a mapped column is read if it is empty
+	tables map[string]bool
+	source string
+
+	// backslash is true if backslashes escape characters in strings, as in MySQL
+	backslash bool
+	// created are the columns of each table, from CREATE TABLE statements
+	created map[string][]string
+
+	// stmt is the start of the current statement, up to VALUES for INSERT statements
+	stmt      []byte
+	stmtQuote byte
+	stmtEsc   bool
+
+	// the table of the current INSERT statement or COPY block
+	inValues bool
+	copying  bool
+	table    string
+	fields   []string
+	names    []string
+	skip     bool
+
+	// the current row of an INSERT statement
+	depth  int
+	values []string
+	value  strings.Builder
+	raw    strings.Builder
+	quote  byte
+	esc    bool
+	strEsc bool
+	quoted bool
+
+	lastLine int64
+	lastEmit Emit
+}
+
+// newSQLParser validates the config of a SQL line parser
+func newSQLParser(columns []string, mapping map[string]string, tables []string, source string) (func() StreamParser, error) {
+	for _, col := range columns {
+		if !validField(col) {
+			return nil, errors.New("unknown column '" + col + "'")
+		}
+	}
+
+	normalised := make(map[string]string, len(mapping))
+	for column, field := range mapping {
+		if !validField(field) {
+			return nil, errors.New("unknown field '" + field + "' for column '" + column + "'")
+		}
+		normalised[normaliseHeader(column)] = strings.ToLower(field)
+	}
+
+	tableSet := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		tableSet[normaliseHeader(t)] = true
+	}
+
+	return func() StreamParser {
+		return &sqlParser{mapping: normalised, columns: columns, tables: tableSet, source: source, backslash: true}
+	}, nil
+}
+
+// unquoteIdent returns the normalised name of a (possibly quoted and schema qualified) identifier
+func unquoteIdent(ident string) string {
+	ident = strings.TrimSpace(ident)
+	if idx := strings.LastIndex(ident, "."); idx >= 0 && !strings.HasSuffix(ident, ".") {
+		ident = ident[idx+1:]
+	}
+	return normaliseHeader(strings.Trim(ident, "`\"[]"))
+}
+
+// splitTopLevel splits s by commas that are not inside quotes or brackets
+func splitTopLevel(s string) []string {
+	var parts []string
+	var depth int
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+		case ch == ',' && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func (p *sqlParser) Parse(line, source string, lineNum int64, emit Emit) error {
+	p.lastEmit, p.lastLine = emit, lineNum
+
+	if p.copying {
+		p.copyRow(line, source, lineNum, emit)
+		return nil
+	}
+
+	for i := 0; i < len(line); {
+		if p.inValues {
+			i = p.parseValues(line, i, source, lineNum, emit)
+		} else {
+			i = p.parseStatement(line, i)
+			if p.copying {
+				// the rows of a COPY block start on the next line
+				return nil
+			}
+		}
+	}
+
+	// the newline is part of any string that spans lines
+	if p.inValues && p.depth > 0 {
+		p.raw.WriteByte('\n')
+		if p.quote != 0 {
+			p.value.WriteByte('\n')
+		}
+	} else if !p.inValues && len(p.stmt) > 0 {
+		p.appendStmt('\n')
+	}
+	return nil
+}
+
+func (p *sqlParser) appendStmt(ch byte) {
+	if len(p.stmt) < maxSQLStatementLength {
+		p.stmt = append(p.stmt, ch)
+	}
+}
+
+// parseStatement reads a statement until its end, or until the VALUES of an INSERT statement. It returns the index of the next character
+func (p *sqlParser) parseStatement(line string, i int) int {
+	// skip comments between statements
+	if len(bytes.TrimSpace(p.stmt)) == 0 && p.stmtQuote == 0 {
+		rest := strings.TrimSpace(line[i:])
+		if strings.HasPrefix(rest, "--") || strings.HasPrefix(rest, "#") {
+			return len(line)
+		}
+	}
+
+	for ; i < len(line); i++ {
+		ch := line[i]
+		p.appendStmt(ch)
+
+		if p.stmtQuote != 0 {
+			if p.stmtEsc {
+				p.stmtEsc = false
+			} else if ch == '\\' && p.backslash && p.stmtQuote == '\'' {
+				p.stmtEsc = true
+			} else if ch == p.stmtQuote {
+				p.stmtQuote = 0
+			}
+			continue
+		}
+
+		switch ch {
+		case '\'', '"', '`':
+			p.stmtQuote = ch
+		case ';':
+			p.endStatement()
+		case 'S', 's':
+			if p.startValues() {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
+
+// startValues checks if the statement so far is an INSERT statement up to VALUES, and prepares the columns of its table
+func (p *sqlParser) startValues() bool {
+	n := len(p.stmt)
+	if n < 6 || !bytes.EqualFold(p.stmt[n-6:], []byte("VALUES")) {
+		return false
+	}
+	match := sqlInsertPattern.FindSubmatch(p.stmt)
+	if match == nil {
+		return false
+	}
+
+	var columns []string
+	if len(match[2]) > 0 {
+		for _, col := range splitTopLevel(string(match[2])) {
+			columns = append(columns, unquoteIdent(col))
+		}
+	}
+	p.setTable(unquoteIdent(string(match[1])), columns)
+	p.inValues = true
+	p.stmt = p.stmt[:0]
+	return true
+}
+
+// endStatement handles a complete statement that is not an INSERT statement
+func (p *sqlParser) endStatement() {
+	stmt := p.stmt
+	p.stmt = p.stmt[:0]
+
+	if match := sqlCreatePattern.FindSubmatch(stmt); match != nil {
+		var columns []string
+		for _, def := range splitTopLevel(string(match[2])) {
+			def = strings.TrimSpace(def)
+			word := def
+			if idx := strings.IndexAny(def, " \t\r\n("); idx >= 0 {
+				word = def[:idx]
+			}
+			if word == "" || sqlConstraints[strings.ToUpper(word)] {
+				continue
+			}
+			columns = append(columns, unquoteIdent(word))
+		}
+		if p.created == nil {
+			p.created = make(map[string][]string)
+		}
+		p.created[unquoteIdent(string(match[1]))] = columns
+	} else if match := sqlCopyPattern.FindSubmatch(stmt); match != nil {
+		var columns []string
+		if len(match[2]) > 0 {
+			for _, col := range splitTopLevel(string(match[2])) {
+				columns = append(columns, unquoteIdent(col))
+			}
+		}
+		p.setTable(unquoteIdent(string(match[1])), columns)
+		p.copying = true
+	} else if sqlStandardStringsPattern.Match(stmt) {
+		p.backslash = false
+	}
+}
+
+// setTable maps the columns of the table whose rows are being read. Tables without a mapped column are skipped
+func (p *sqlParser) setTable(table string, columns []string) {
+	p.table, p.fields, p.names = table, nil, nil
+	p.skip = len(p.tables) > 0 && !p.tables[table]
+	if p.skip {
+		return
+	}
+
+	if columns == nil {
+		columns = p.created[table]
+	}
+	if columns == nil {
+		// without column names, use the configured columns or report every row
+		if len(p.columns) > 0 {
+			p.fields = make([]string, len(p.columns))
+			p.names = make([]string, len(p.columns))
+			for i, col := range p.columns {
+				p.fields[i] = strings.ToLower(col)
+				p.names[i] = "column" + strconv.Itoa(i+1)
+			}
+		}
+		return
+	}
+
+	known := 0
+	p.fields = make([]string, len(columns))
+	p.names = columns
+	for i, col := range columns {
+		p.fields[i] = columnField(p.mapping, col)
+		if p.fields[i] != "" && p.fields[i] != fieldSkip {
+			known++
+		}
+	}
+	p.skip = known == 0
+}
+
+// parseValues reads the rows of an INSERT statement, emitting each row as it ends. It returns the index of the next character
+func (p *sqlParser) parseValues(line string, i int, source string, lineNum int64, emit Emit) int {
+	for ; i < len(line); i++ {
+		ch := line[i]
+
+		// between rows
+		if p.depth == 0 {
+			switch ch {
+			case ' ', '\t', '\r', ',':
+			case '(':
+				p.depth = 1
+				p.values = p.values[:0]
+				p.raw.Reset()
+				p.raw.WriteByte(ch)
+			case ';':
+				p.inValues = false
+				return i + 1
+			default:
+				// the rest of the statement, e.g. ON DUPLICATE KEY UPDATE
+				p.inValues = false
+				return i
+			}
+			continue
+		}
+
+		if !p.skip {
+			p.raw.WriteByte(ch)
+		}
+
+		if p.quote != 0 {
+			switch {
+			case p.esc:
+				p.esc = false
+				p.value.WriteString(unescapeSQL(ch))
+			case ch == '\\' && p.strEsc:
+				p.esc = true
+			case ch == p.quote:
+				if i+1 < len(line) && line[i+1] == p.quote {
+					// a doubled quote is a literal quote
+					p.value.WriteByte(ch)
+					if !p.skip {
+						p.raw.WriteByte(ch)
+					}
+					i++
+				} else {
+					p.quote = 0
+				}
+			default:
+				p.value.WriteByte(ch)
+			}
+			continue
+		}
+
+		switch ch {
+		case '\'', '"':
+			// drop prefixes like _binary, _utf8mb4, N and E, where E enables backslash escapes in PostgreSQL
+			prefix := strings.TrimSpace(p.value.String())
+			p.strEsc = p.backslash || strings.EqualFold(prefix, "E")
+			p.value.Reset()
+			p.quote, p.quoted = ch, true
+		case '(':
+			p.depth++
+			p.value.WriteByte(ch)
+		case ')':
+			if p.depth == 1 {
+				p.endValue()
+				p.depth = 0
+				p.emitRow(p.values, p.raw.String(), source, lineNum, emit)
+			} else {
+				p.depth--
+				p.value.WriteByte(ch)
+			}
+		case ',':
+			if p.depth == 1 {
+				p.endValue()
+			} else {
+				p.value.WriteByte(ch)
+			}
+		case ' ', '\t', '\r':
+			if p.depth > 1 {
+				p.value.WriteByte(ch)
+			}
+		default:
+			p.value.WriteByte(ch)
+		}
+	}
+	return i
+}
+
+// endValue adds the current value to the row. Unquoted NULLs are empty
+func (p *sqlParser) endValue() {
+	v := p.value.String()
+	if !p.quoted && strings.EqualFold(v, "NULL") {
+		v = ""
+	}
+	p.values = append(p.values, v)
+	p.value.Reset()
+	p.quoted = false
+}
+
+// unescapeSQL returns the character that is escaped by a backslash
+func unescapeSQL(ch byte) string {
+	switch ch {
+	case '0':
+		return "\x00"
+	case 'b':
+		return "\b"
+	case 'n':
+		return "\n"
+	case 'r':
+		return "\r"
+	case 't':
+		return "\t"
+	case 'Z':
+		return "\x1a"
+	}
+	return string(ch)
+}
+
+// copyRow reads a row of a PostgreSQL COPY block, in its tab separated text format
+func (p *sqlParser) copyRow(line, source string, lineNum int64, emit Emit) {
+	if line == `\.` {
+		p.copying = false
+		return
+	}
+	if p.skip {
+		return
+	}
+
+	values := strings.Split(line, "\t")
+	for i, v := range values {
+		if v == `\N` {
+			values[i] = ""
+		} else if strings.Contains(v, `\`) {
+			values[i] = unescapeCopy(v)
+		}
+	}
+	p.emitRow(values, line, source, lineNum, emit)
+}
+
+// unescapeCopy decodes the backslash escapes of the COPY text format
+func unescapeCopy(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' || i+1 == len(v) {
+			b.WriteByte(v[i])
+			continue
+		}
+		i++
+		switch ch := v[i]; {
+		case ch >= '0' && ch <= '7':
+			j := i
+			for j < len(v) && j < i+3 && v[j] >= '0' && v[j] <= '7' {
+				j++
+			}
+			n, _ := strconv.ParseUint(v[i:j], 8, 8)
+			b.WriteByte(byte(n))
+			i = j - 1
+		case ch == 'x' && i+1 < len(v):
+			j := i + 1
+			for j < len(v) && j < i+3 && strings.IndexByte("0123456789abcdefABCDEF", v[j]) >= 0 {
+				j++
+			}
+			if j == i+1 {
+				b.WriteByte(ch)
+				continue
+			}
+			n, _ := strconv.ParseUint(v[i+1:j], 16, 8)
+			b.WriteByte(byte(n))
+			i = j - 1
+		case ch == 'f':
+			b.WriteByte('\f')
+		case ch == 'v':
+			b.WriteByte('\v')
+		default:
+			b.WriteString(unescapeSQL(ch))
+		}
+	}
+	return b.String()
+}
+
+// emitRow maps the values of a row to a record
+func (p *sqlParser) emitRow(values []string, raw, source string, lineNum int64, emit Emit) {
+	if p.skip {
+		return
+	}
+	if p.fields == nil {
+		emit(raw, lineNum, Record{}, errors.New("Unknown columns for table "+p.table))
+		return
+	}
+	if len(values) != len(p.fields) {
+		emit(raw, lineNum, Record{}, errors.New("Incorrect number of columns"))
+		return
+	}
+
+	result := mapColumns(p.fields, p.names, values)
+	result.Source = source
+	if p.source != "" {
+		result.Source = p.source
+	}
+	emit(raw, lineNum, result, nil)
+}
+
+// Flush fails a row that was not finished by the end of the file, then starts the next file
+func (p *sqlParser) Flush() error {
+	if p.inValues && p.depth > 0 && !p.skip && p.lastEmit != nil {
+		p.lastEmit(p.raw.String(), p.lastLine, Record{}, errors.New("Unterminated INSERT statement"))
+	}
+	*p = sqlParser{mapping: p.mapping, columns: p.columns, tables: p.tables, source: p.source, backslash: true}
+	return nil
+}
@@ -0,0 +1,69 @@
+package parseline
+
+import "testing"
+
+func TestSQL(t *testing.T) {
+	lines := []string{
+		"-- MySQL dump 10.13",
+		"/*!40101 SET NAMES utf8mb4 */;",
+		"CREATE TABLE `users` (",
+		"  `id` int(11) NOT NULL,",
+		"  `email` varchar(255) DEFAULT NULL,",
+		"  `password_hash` char(32) NOT NULL,",
+		"  `bio` text,",
+		"  PRIMARY KEY (`id`)",
+		") ENGINE=InnoDB;",
+		"CREATE TABLE `posts` (`id` int, `body` text);",
+		"INSERT INTO `posts` VALUES (1,'not a credential');",
+		"INSERT INTO `users` VALUES (1,'a@example.com','5f4dcc3b5aa765d61d8327deb882cf99','it\\'s me'),(2,NULL,'x','two",
+		"lines; (with parens)'),(3,'b@example.com');",
+		"INSERT INTO users (email, pass) VALUES ('c@example.org', _binary 'p''w');",
+	}
+	records, errCount := streamLines(t, "sql", lines)
+
+	want := []Record{
+		{Source: "src", Email: "a@example.com", Hash: "5f4dcc3b5aa765d61d8327deb882cf99", Extra: `id=1 bio="it's me"`},
+		{Source: "src", Hash: "x", Extra: "id=2 bio=\"two\\nlines; (with parens)\""},
+		{Source: "src", Email: "c@example.org", Password: "p'w"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d: %+v", len(records), len(want), records)
+	}
+	for i := range want {
+		if records[i] != want[i] {
+			t.Errorf("record %d = %+v, want %+v", i, records[i], want[i])
+		}
+	}
+	if errCount != 1 {
+		t.Errorf("got %d errors, want 1", errCount)
+	}
+
+	if name, _ := Detect(lines); name != "sql" {
+		t.Errorf("Detect = %s, want sql", name)
+	}
+}
+
+func TestSQLCopy(t *testing.T) {
+	lines := []string{
+		"SET standard_conforming_strings = on;",
+		`COPY public.accounts (id, login, passwd) FROM stdin;`,
+		"1\tbob\thunter\\t2",
+		"2\t\\N\tsecret",
+		`\.`,
+		`INSERT INTO public.accounts VALUES (3, 'c:\dir', 'pw');`,
+	}
+	records, _ := streamLines(t, "sql", lines)
+
+	want := []Record{
+		{Source: "src", Username: "bob", Password: "hunter\t2", Extra: "id=1"},
+		{Source: "src", Password: "secret", Extra: "id=2"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d: %+v", len(records), len(want), records)
+	}
+	for i := range want {
+		if records[i] != want[i] {
+			t.Errorf("record %d = %+v, want %+v", i, records[i], want[i])
+		}
+	}
+}
@@ -1,5 +1,14 @@
 package parseline
 
+import (
+	"errors"
+	"strings"
+)
+
+// ErrFieldSeparator occurs when a field contains a tab or newline, which would split the record in the tab-delimited
+// batch files that are loaded into the database
+var ErrFieldSeparator = errors.New("A field contains a tab or newline")
+
 // A Record represents a single row in the database
 type Record struct {
 	Source   string
@@ -11,3 +20,14 @@ type Record struct {
 	Password string
 	Extra    string
 }
+
+// Check returns ErrFieldSeparator if a field can't be written to a batch file. Batch files are loaded without an
+// escape character, so that backslashes in passwords are kept as they are
+func (r Record) Check() error {
+	for _, v := range []string{r.Source, r.Username, r.Email, r.EmailRev, r.Hash, r.Password, r.Extra} {
+		if strings.ContainsAny(v, "\t\n") {
+			return ErrFieldSeparator
+		}
+	}
+	return nil
+}
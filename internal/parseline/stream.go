@@ -0,0 +1,54 @@
+package parseline
+
+// An Emit callback receives a parsed record, or the error and raw line(s) of a line that could not be parsed.
+// lineNum is the last line that the record was parsed from. Results are emitted in the same order as the lines were parsed,
+// on the goroutine that calls Parse or Flush
+type Emit func(line string, lineNum int64, r Record, err error)
+
+// A StreamParser parses a stream of lines, emitting zero or more records for each line.
+// A record may be emitted after later lines are parsed, e.g. for records spread over several lines
+type StreamParser interface {
+	// Parse queues a line to be parsed, with the source name to use for its record
+	Parse(line, source string, lineNum int64, emit Emit) error
+	// Flush emits every remaining record. It is called at the end of each file
+	Flush() error
+}
+
+// funcStream adapts a line parser function to a StreamParser, emitting each result immediately
+type funcStream func(line, source string) (Record, error)
+
+func (f funcStream) Parse(line, source string, lineNum int64, emit Emit) error {
+	r, err := f(line, source)
+	emit(line, lineNum, r, err)
+	return nil
+}
+
+func (f funcStream) Flush() error {
+	return nil
+}
+
+// Stream returns the requested line parser as a StreamParser
+func Stream(name string) (StreamParser, error) {
+	name = parserName(name)
+	if p, ok := execParsers[name]; ok {
+		return p, nil
+	}
+	if newParser, ok := streamParsers[name]; ok {
+		return newParser(), nil
+	}
+	if parser, ok := lineParsers[name]; ok {
+		return funcStream(parser), nil
+	}
+	return nil, ErrInvalidLineParser
+}
+
+// CloseAll stops the subprocesses of any external line parsers that were started
+func CloseAll() error {
+	var firstErr error
+	for _, p := range execParsers {
+		if err := p.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
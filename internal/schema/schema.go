@@ -0,0 +1,152 @@
+package schema
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Version is the schema version of databases created by `init`, and the version that `migrate` upgrades databases to
+const Version = "0.0.4"
+
+// the types of DumpDB databases, stored under `type` in the metadata table
+const (
+	TypeMain    = "main"
+	TypeSources = "sources"
+)
+
+// A Migration upgrades a database from the previous schema version to Version
+type Migration struct {
+	Version     string
+	Description string
+	// Main and Sources are the statements that upgrade each type of database, in order
+	Main    []string
+	Sources []string
+}
+
+// Migrations are every migration, in order of their versions. The last one is always Version.
+// 0.0.4 is the baseline: databases created before migrations existed already have it, so it has no statements
+var Migrations = []Migration{
+	{
+		Version:     "0.0.4",
+		Description: "baseline schema of the first versioned databases",
+	},
+}
+
+// Statements returns the statements that upgrade a type of database
+func (m Migration) Statements(dbType string) []string {
+	if dbType == TypeSources {
+		return m.Sources
+	}
+	return m.Main
+}
+
+// parseVersion splits a version like 0.0.4 into its numbers
+func parseVersion(v string) ([]int, error) {
+	parts := strings.Split(v, ".")
+	nums := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, errors.New("invalid schema version \"" + v + "\"")
+		}
+		nums[i] = n
+	}
+	return nums, nil
+}
+
+// compareVersions returns -1, 0 or 1 if version a is older than, the same as, or newer than version b
+func compareVersions(a, b string) (int, error) {
+	av, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	bv, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < len(av) || i < len(bv); i++ {
+		var x, y int
+		if i < len(av) {
+			x = av[i]
+		}
+		if i < len(bv) {
+			y = bv[i]
+		}
+		if x < y {
+			return -1, nil
+		} else if x > y {
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// Plan returns the migrations that upgrade a database from schema version `from` to Version, in the order they run
+func Plan(from string) ([]Migration, error) {
+	cmp, err := compareVersions(from, Version)
+	if err != nil {
+		return nil, err
+	}
+	if cmp > 0 {
+		return nil, errors.New("schema version " + from + " is newer than " + Version + ". Upgrade DumpDB to use this database")
+	}
+
+	var plan []Migration
+	for _, m := range Migrations {
+		if cmp, _ := compareVersions(m.Version, from); cmp > 0 {
+			plan = append(plan, m)
+		}
+	}
+	return plan, nil
+}
+
+// ReadVersion reads the type and schema version of a database from its metadata table
+func ReadVersion(conn *sql.DB) (dbType, version string, err error) {
+	err = conn.QueryRow("SELECT v FROM metadata WHERE k='type'").Scan(&dbType)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = conn.QueryRow("SELECT v FROM metadata WHERE k='schema_version'").Scan(&version)
+	if err == sql.ErrNoRows {
+		return dbType, "", errors.New("The database has no schema version. It was not created by `dumpdb init`")
+	}
+	return dbType, version, err
+}
+
+// Check returns an error unless the database has the current schema version
+func Check(conn *sql.DB) error {
+	_, version, err := ReadVersion(conn)
+	if err != nil {
+		return err
+	}
+
+	plan, err := Plan(version)
+	if err != nil {
+		return errors.New("Unknown database schema: " + err.Error())
+	}
+	if len(plan) > 0 {
+		return errors.New("The database has schema version " + version + " but " + Version + " is required. Run `dumpdb migrate` to upgrade it")
+	}
+	return nil
+}
+
+// Apply runs the statements of a migration, then records its version in the metadata table.
+// Databases are upgraded one migration at a time, so an interrupted upgrade continues from the last migration that finished
+func Apply(conn *sql.DB, dbType string, m Migration) error {
+	for _, stmt := range m.Statements(dbType) {
+		if _, err := conn.Exec(stmt); err != nil {
+			return errors.New(m.Version + ": " + err.Error())
+		}
+	}
+
+	_, err := conn.Exec(`
+		INSERT INTO metadata (k, v)
+		VALUES ('schema_version', ?)
+		ON DUPLICATE KEY UPDATE v=VALUES(v)
+	`, m.Version)
+	return err
+}
@@ -0,0 +1,56 @@
+package schema
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"0.0.4", "0.0.4", 0},
+		{"0.0.4", "0.0.5", -1},
+		{"0.1.0", "0.0.9", 1},
+		{"0.0.10", "0.0.9", 1},
+		{"1.0", "1.0.0", 0},
+	}
+	for _, tt := range tests {
+		got, err := compareVersions(tt.a, tt.b)
+		if err != nil || got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, %v, want %d", tt.a, tt.b, got, err, tt.want)
+		}
+	}
+
+	if _, err := compareVersions("0.0.x", "0.0.4"); err == nil {
+		t.Error("expected an error for an invalid version")
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	for i := 1; i < len(Migrations); i++ {
+		if cmp, err := compareVersions(Migrations[i-1].Version, Migrations[i].Version); err != nil || cmp >= 0 {
+			t.Errorf("migration %s is not after %s", Migrations[i].Version, Migrations[i-1].Version)
+		}
+	}
+	if last := Migrations[len(Migrations)-1].Version; last != Version {
+		t.Errorf("the last migration is %s, want %s", last, Version)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	plan, err := Plan("0.0.3")
+	if err != nil || len(plan) != len(Migrations) {
+		t.Errorf("Plan(0.0.3) = %d migrations, %v, want %d", len(plan), err, len(Migrations))
+	}
+
+	plan, err = Plan(Version)
+	if err != nil || len(plan) != 0 {
+		t.Errorf("Plan(%s) = %d migrations, %v, want none", Version, len(plan), err)
+	}
+
+	if _, err := Plan("99.0.0"); err == nil {
+		t.Error("expected an error for a newer version")
+	}
+	if _, err := Plan(""); err == nil {
+		t.Error("expected an error for an empty version")
+	}
+}
@@ -2,7 +2,6 @@ package sourceid
 
 import (
 	"database/sql"
-	"strings"
 
 	lru "github.com/hashicorp/golang-lru"
 )
@@ -38,20 +37,15 @@ func SourceName(id int64, sourcesDb *sql.DB, sourcesTable string) (string, error
 	}
 
 	// save in cache
-	sourceNameCache.Add(s, id)
+	sourceNameCache.Add(id, s)
 	return s, nil
 }
 
 // SourceID fetches an integer ID for a string `s` from the sources table
 func SourceID(s string, sourcesDb *sql.DB, sourcesTable string) (int64, error) {
-	if strings.HasPrefix(s, "./") {
-		s = s[2:]
-	}
-
-	// truncate source name to 250 characters (database limitation)
-	if len(s) > 250 {
-		s = s[:250]
-	}
+	// shorten long source names to fit in the database, without merging different sources
+	long := s
+	s = Fit(s)
 
 	// load from cache
 	val, ok := sourceIDCache.Get(s)
@@ -62,6 +56,22 @@ func SourceID(s string, sourcesDb *sql.DB, sourcesTable string) (int64, error) {
 		}
 	}
 
+	// older versions cut long names at MaxLength, keep using their rows so that existing source IDs stay the same
+	if s != long {
+		var id int64
+		err := sourcesDb.QueryRow(`
+			SELECT id
+			FROM `+sourcesTable+`
+			WHERE name=?
+		`, long[:MaxLength]).Scan(&id)
+		if err == nil {
+			sourceIDCache.Add(s, id)
+			return id, nil
+		} else if err != sql.ErrNoRows {
+			return 0, err
+		}
+	}
+
 	// upsert from database
 	res, err := sourcesDb.Exec(`
 		INSERT INTO `+sourcesTable+` (name) VALUES (?)
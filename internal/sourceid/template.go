@@ -0,0 +1,141 @@
+package sourceid
+
+import (
+	"errors"
+	"hash/fnv"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxLength is the maximum length of a source name (database limitation)
+const MaxLength = 250
+
+// DefaultTemplate names each source by the name that the line parser gives it, or the path of its file
+const DefaultTemplate = "{source}"
+
+// Vars are the values of the placeholders in a source name template
+type Vars struct {
+	// Path is the path of the file, including any archives it is in, e.g. dumps/outer.zip/inner/users.txt
+	Path string
+	// Source is the source name from the line parser, which is usually the path
+	Source string
+	// Archive is the path of the outermost archive that the file is in, e.g. dumps/outer.zip
+	Archive string
+	// Member is the path of the file inside Archive, e.g. inner/users.txt, or Path if it is not in an archive
+	Member string
+	// Database is the name of the database that the records are imported into
+	Database string
+	// Parser is the name of the line parser
+	Parser string
+}
+
+// placeholders returns the value of each placeholder
+var placeholders = map[string]func(v *Vars) string{
+	"path":     func(v *Vars) string { return v.Path },
+	"source":   func(v *Vars) string { return v.Source },
+	"archive":  func(v *Vars) string { return v.Archive },
+	"member":   func(v *Vars) string { return v.Member },
+	"database": func(v *Vars) string { return v.Database },
+	"parser":   func(v *Vars) string { return v.Parser },
+	"dirname":  func(v *Vars) string { return path.Dir(v.Path) },
+	"basename": func(v *Vars) string { return path.Base(v.Path) },
+}
+
+// A Template builds source names from placeholders like {archive}/{member}
+type Template struct {
+	// literal text and placeholder names alternate, starting with literal text
+	parts []string
+}
+
+// ParseTemplate parses a source name template. Placeholders are written in braces, e.g. {dirname}
+func ParseTemplate(s string) (Template, error) {
+	if s == "" {
+		s = DefaultTemplate
+	}
+
+	var t Template
+	for {
+		start := strings.Index(s, "{")
+		if start < 0 {
+			t.parts = append(t.parts, s)
+			return t, nil
+		}
+		end := strings.Index(s[start:], "}")
+		if end < 0 {
+			return Template{}, errors.New("unclosed placeholder in source name template")
+		}
+		name := s[start+1 : start+end]
+		if _, ok := placeholders[name]; !ok {
+			return Template{}, errors.New("unknown placeholder {" + name + "} in source name template")
+		}
+		t.parts = append(t.parts, s[:start], name)
+		s = s[start+end+1:]
+	}
+}
+
+// UsesSource checks if the template uses the source name from the line parser, which may be different for each record
+func (t Template) UsesSource() bool {
+	for i := 1; i < len(t.parts); i += 2 {
+		if t.parts[i] == "source" {
+			return true
+		}
+	}
+	return false
+}
+
+// Render builds a source name. Empty path elements are removed, so {database}/{archive}/{member} works for files outside archives
+func (t Template) Render(v Vars) string {
+	v.Path = cleanPath(v.Path)
+	v.Source = cleanPath(v.Source)
+	v.Archive = cleanPath(v.Archive)
+	v.Member = cleanPath(v.Member)
+	if v.Source == "" {
+		v.Source = v.Path
+	}
+
+	var b strings.Builder
+	for i, part := range t.parts {
+		if i%2 == 0 {
+			b.WriteString(part)
+		} else {
+			b.WriteString(placeholders[part](&v))
+		}
+	}
+
+	elems := strings.Split(b.String(), "/")
+	kept := elems[:0]
+	for _, e := range elems {
+		if e != "" {
+			kept = append(kept, e)
+		}
+	}
+	return strings.Join(kept, "/")
+}
+
+// cleanPath uses forward slashes and removes a leading ./
+func cleanPath(p string) string {
+	return strings.TrimPrefix(filepath.ToSlash(p), "./")
+}
+
+// Fit shortens source names that are longer than MaxLength, replacing the end with a hash of the whole name so that
+// names with the same beginning stay distinct. Older versions cut names at MaxLength instead, which SourceID still
+// looks up so that names they stored keep their source ID
+func Fit(s string) string {
+	if len(s) <= MaxLength {
+		return s
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	suffix := "~" + strconv.FormatUint(h.Sum64(), 36)
+
+	// don't cut a multi-byte character in half
+	cut := MaxLength - len(suffix)
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + suffix
+}
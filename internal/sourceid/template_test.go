@@ -0,0 +1,60 @@
+package sourceid
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemplate(t *testing.T) {
+	inArchive := Vars{Path: "./dumps/outer.zip/inner/users.txt", Source: "./dumps/outer.zip/inner/users.txt", Archive: "./dumps/outer.zip", Member: "inner/users.txt", Database: "main", Parser: "csv"}
+	plain := Vars{Path: "dumps/users.txt", Member: "dumps/users.txt", Parser: "adobe"}
+
+	tests := []struct {
+		template string
+		v        Vars
+		want     string
+	}{
+		{"", inArchive, "dumps/outer.zip/inner/users.txt"},
+		{"", plain, "dumps/users.txt"},
+		{"{database}/{archive}/{member}", inArchive, "main/dumps/outer.zip/inner/users.txt"},
+		{"{database}/{archive}/{member}", plain, "dumps/users.txt"},
+		{"{dirname}", inArchive, "dumps/outer.zip/inner"},
+		{"{parser}:{basename}", plain, "adobe:users.txt"},
+		{"collection1", plain, "collection1"},
+	}
+	for _, tt := range tests {
+		tmpl, err := ParseTemplate(tt.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tmpl.Render(tt.v); got != tt.want {
+			t.Errorf("Render(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+
+	for _, invalid := range []string{"{unknown}", "{path"} {
+		if _, err := ParseTemplate(invalid); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestFit(t *testing.T) {
+	short := "dumps/users.txt"
+	if got := Fit(short); got != short {
+		t.Errorf("Fit(%q) = %q", short, got)
+	}
+
+	a := strings.Repeat("é", 200) + "/a.txt"
+	b := strings.Repeat("é", 200) + "/b.txt"
+	fa, fb := Fit(a), Fit(b)
+	if len(fa) > MaxLength || len(fb) > MaxLength {
+		t.Errorf("Fit returned %d and %d bytes, want at most %d", len(fa), len(fb), MaxLength)
+	}
+	if fa == fb {
+		t.Errorf("Fit returned the same name for different sources: %q", fa)
+	}
+	if !strings.HasPrefix(fa, strings.Repeat("é", 100)) || !strings.Contains(fa, "~") {
+		t.Errorf("Fit(%q) = %q, want the start of the name and a hash", a, fa)
+	}
+}
@@ -0,0 +1,76 @@
+package globmatch
+
+import (
+	"path"
+	"strings"
+)
+
+// Match reports whether the slash-separated `name` matches the shell pattern.
+// It supports the same syntax as path.Match, plus `**` which matches zero or more directories.
+func Match(pattern, name string) bool {
+	return matchParts(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// MatchBase matches patterns without a slash against the last element of `name`,
+// and patterns with a slash against the whole of `name` (similar to .gitignore).
+func MatchBase(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		return Match(pattern, path.Base(name))
+	}
+	return Match(pattern, name)
+}
+
+// MatchAny reports whether `name` matches any of the patterns, using MatchBase
+func MatchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if MatchBase(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that the pattern is well-formed
+func Validate(pattern string) error {
+	for _, p := range strings.Split(pattern, "/") {
+		if p == "**" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchParts(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// collapse repeated **
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			// try matching the rest of the pattern at every depth
+			for i := range name {
+				if matchParts(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		ok, err := path.Match(pattern[0], name[0])
+		if err != nil || !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
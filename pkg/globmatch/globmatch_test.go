@@ -0,0 +1,38 @@
+package globmatch
+
+import "testing"
+
+// TestMatch tests matching with and without **
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.txt", "a.txt", true},
+		{"*.txt", "dir/a.txt", false},
+		{"**/*.txt", "a.txt", true},
+		{"**/*.txt", "dir/sub/a.txt", true},
+		{"dir/**", "dir/sub/a.txt", true},
+		{"dir/**/a.txt", "dir/a.txt", true},
+		{"*/adobe*/*.txt", "dump/adobe2013/users.txt", true},
+		{"*/adobe*/*.txt", "dump/other/users.txt", false},
+		{"**/*.csv", "x/y/z.txt", false},
+	}
+
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, expected %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestMatchBase tests that patterns without a slash match the base name
+func TestMatchBase(t *testing.T) {
+	if !MatchBase("*.txt", "dir/sub/a.txt") {
+		t.Error("Expected *.txt to match dir/sub/a.txt")
+	}
+	if MatchBase("sub/*.txt", "dir/sub/a.txt") {
+		t.Error("Expected sub/*.txt to not match dir/sub/a.txt")
+	}
+}
@@ -33,6 +33,11 @@ func Create(namePrefix, nameSuffix string, maxWrites int) (*SplitFileWriter, err
 	return New(namePrefix, nameSuffix, 0, maxWrites, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666, defaultBufSize, nil)
 }
 
+// CreateAt calls os.Create for the file numbered `currentInc` and then creates a new SplitFileWriter from it
+func CreateAt(namePrefix, nameSuffix string, currentInc, maxWrites int) (*SplitFileWriter, error) {
+	return New(namePrefix, nameSuffix, currentInc, maxWrites, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666, defaultBufSize, nil)
+}
+
 // Open calls os.OpenFile and then creates a new SplitFileWriter from it
 func Open(namePrefix, nameSuffix string, maxWrites, fileFlag int, filePerm os.FileMode) (*SplitFileWriter, error) {
 	return New(namePrefix, nameSuffix, 0, maxWrites, fileFlag, filePerm, defaultBufSize, nil)
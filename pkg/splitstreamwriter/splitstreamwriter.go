@@ -0,0 +1,123 @@
+package splitstreamwriter
+
+import (
+	"bufio"
+	"io"
+)
+
+const (
+	defaultBufSize = 64 * 1024
+)
+
+// SplitStreamWriter is a bufio writer which writes to a different stream every `n` writes. Each stream is read by a
+// consumer while it is written, so nothing is stored on disk
+type SplitStreamWriter struct {
+	MaxWrites int
+
+	CurrentPipe *io.PipeWriter
+	CurrentBuf  *bufio.Writer
+
+	WriteCount int
+	CurrentInc int
+
+	// Consumer reads each stream in its own goroutine. The stream is finished once Consumer returns
+	Consumer func(inc int, r io.Reader) error
+	// StreamDoneCallback is called after Consumer has finished reading each stream, including the last one
+	StreamDoneCallback func(*SplitStreamWriter) error
+
+	bufSize  int
+	consumed chan error
+}
+
+// New creates a SplitStreamWriter whose first stream is numbered `currentInc`. Streams are only started when they are
+// written to
+func New(currentInc, maxWrites int, consumer func(inc int, r io.Reader) error) *SplitStreamWriter {
+	return &SplitStreamWriter{
+		MaxWrites:  maxWrites,
+		CurrentInc: currentInc,
+		Consumer:   consumer,
+		bufSize:    defaultBufSize,
+	}
+}
+
+// Flush writes any buffered data to the current stream.
+func (s *SplitStreamWriter) Flush() error {
+	if s.CurrentBuf == nil {
+		return nil
+	}
+	return s.CurrentBuf.Flush()
+}
+
+// Write writes the contents of p into the buffer.
+func (s *SplitStreamWriter) Write(p []byte) (int, error) {
+	err := s.preWrite()
+	if err != nil {
+		return 0, err
+	}
+	return s.CurrentBuf.Write(p)
+}
+
+// WriteString writes a string. It returns the number of bytes written.
+func (s *SplitStreamWriter) WriteString(st string) (int, error) {
+	err := s.preWrite()
+	if err != nil {
+		return 0, err
+	}
+	return s.CurrentBuf.WriteString(st)
+}
+
+// Close finishes the current stream, waiting for Consumer to read it.
+func (s *SplitStreamWriter) Close() error {
+	if s.CurrentPipe == nil {
+		return nil
+	}
+	return s.finish()
+}
+
+// start creates the next stream and starts Consumer reading it
+func (s *SplitStreamWriter) start() {
+	pr, pw := io.Pipe()
+	s.CurrentPipe = pw
+	s.CurrentBuf = bufio.NewWriterSize(pw, s.bufSize)
+	s.WriteCount = 0
+
+	consumed := make(chan error, 1)
+	s.consumed = consumed
+	go func(inc int) {
+		err := s.Consumer(inc, pr)
+		// stop any writes that the consumer will never read
+		pr.CloseWithError(err)
+		consumed <- err
+	}(s.CurrentInc)
+}
+
+// finish closes the current stream, waits for Consumer to return and moves on to the number of the next stream
+func (s *SplitStreamWriter) finish() error {
+	flushErr := s.CurrentBuf.Flush()
+	s.CurrentPipe.Close()
+	err := <-s.consumed
+	s.CurrentPipe, s.CurrentBuf = nil, nil
+	if err == nil {
+		err = flushErr
+	}
+	if err == nil && s.StreamDoneCallback != nil {
+		err = s.StreamDoneCallback(s)
+	}
+	s.CurrentInc++
+	return err
+}
+
+// preWrite increments the writeCount and starts a new stream if required
+func (s *SplitStreamWriter) preWrite() error {
+	if s.CurrentPipe != nil && s.WriteCount >= s.MaxWrites {
+		err := s.finish()
+		if err != nil {
+			return err
+		}
+	}
+	if s.CurrentPipe == nil {
+		s.start()
+	}
+	s.WriteCount++
+	return nil
+}
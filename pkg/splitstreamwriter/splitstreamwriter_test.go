@@ -0,0 +1,73 @@
+package splitstreamwriter
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"testing"
+)
+
+// TestSplitStreamWriter tests the splitstreamwriter
+func TestSplitStreamWriter(t *testing.T) {
+	const numWritesPerStream = 10
+	const writeCount = 888
+	const testString = "Testing abcdefghijkmnopqrstuvwxyz: "
+
+	streams := make(map[int]string)
+	var done []int
+	s := New(3, numWritesPerStream, func(inc int, r io.Reader) error {
+		b, err := ioutil.ReadAll(r)
+		streams[inc] = string(b)
+		return err
+	})
+	s.StreamDoneCallback = func(s *SplitStreamWriter) error {
+		done = append(done, s.CurrentInc)
+		return nil
+	}
+
+	for i := 0; i < writeCount; i++ {
+		if _, err := s.WriteString(testString + strconv.Itoa(i) + "\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	numStreams := (writeCount + numWritesPerStream - 1) / numWritesPerStream
+	if len(streams) != numStreams || len(done) != numStreams {
+		t.Fatalf("expected %d streams, found %d streams and %d callbacks", numStreams, len(streams), len(done))
+	}
+	for i := 0; i < writeCount; {
+		inc := 3 + i/numWritesPerStream
+		var str string
+		for j := 0; j < numWritesPerStream && i < writeCount; j++ {
+			str += testString + strconv.Itoa(i) + "\n"
+			i++
+		}
+		if streams[inc] != str {
+			t.Errorf("Strings did not match. Expected %s, found %s", str, streams[inc])
+		}
+	}
+}
+
+// TestConsumerError tests that writes fail once the consumer stops reading
+func TestConsumerError(t *testing.T) {
+	errLoad := errors.New("load failed")
+	s := New(0, 1000, func(inc int, r io.Reader) error {
+		return errLoad
+	})
+	s.bufSize = 16
+
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		_, err = s.WriteString("a line that is longer than the buffer\n")
+	}
+	if err != errLoad {
+		t.Errorf("expected the consumer's error, found %v", err)
+	}
+	if err := s.Close(); err != errLoad {
+		t.Errorf("expected Close to return the consumer's error, found %v", err)
+	}
+}
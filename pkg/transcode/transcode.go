@@ -0,0 +1,156 @@
+package transcode
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/encoding/unicode/utf32"
+)
+
+// SampleSize is the number of bytes used to detect the encoding of a stream
+const SampleSize = 64 * 1024
+
+// Auto is the encoding name that means the encoding is detected from the contents of the stream
+const Auto = "auto"
+
+const utf8Name = "utf-8"
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+	bomUTF32LE = []byte{0xff, 0xfe, 0x00, 0x00}
+	bomUTF32BE = []byte{0x00, 0x00, 0xfe, 0xff}
+)
+
+// Lookup checks that the encoding name is supported, e.g. auto, utf-8, utf-16le, latin1, cp1251
+func Lookup(name string) (encoding.Encoding, error) {
+	name = strings.ToLower(name)
+	if name == "" || name == Auto {
+		return nil, nil
+	}
+	e, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, errors.New("Unknown encoding: " + name)
+	}
+	return e, nil
+}
+
+// NewReader returns a reader that transcodes `r` to UTF-8, along with the name of the source encoding.
+// If `name` is "auto" then the encoding is detected from the start of the stream.
+// UTF-8 streams are passed through unchanged, so they may still contain invalid UTF-8 sequences.
+func NewReader(r io.Reader, name string) (io.Reader, string, error) {
+	e, err := Lookup(name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	br := bufio.NewReaderSize(r, SampleSize)
+	if e == nil {
+		// a short stream returns an error, but still returns what is available
+		sample, _ := br.Peek(SampleSize)
+		e, name = Detect(sample)
+	} else {
+		name, _ = htmlindex.Name(e)
+	}
+
+	if e == unicode.UTF8 {
+		return br, utf8Name, nil
+	}
+	return e.NewDecoder().Reader(br), name, nil
+}
+
+// Detect guesses the encoding of a sample from the start of a stream.
+// It checks for a byte order mark, then UTF-16 without a BOM, then falls back to a single byte encoding if the sample is not valid UTF-8.
+func Detect(sample []byte) (encoding.Encoding, string) {
+	switch {
+	case bytes.HasPrefix(sample, bomUTF8):
+		return unicode.UTF8BOM, "utf-8 (BOM)"
+	case bytes.HasPrefix(sample, bomUTF32LE):
+		return utf32.UTF32(utf32.LittleEndian, utf32.ExpectBOM), "utf-32le"
+	case bytes.HasPrefix(sample, bomUTF32BE):
+		return utf32.UTF32(utf32.BigEndian, utf32.ExpectBOM), "utf-32be"
+	case bytes.HasPrefix(sample, bomUTF16LE):
+		return unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM), "utf-16le"
+	case bytes.HasPrefix(sample, bomUTF16BE):
+		return unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM), "utf-16be"
+	}
+
+	if e, name := detectUTF16(sample); e != nil {
+		return e, name
+	}
+
+	if validUTF8(sample) {
+		return unicode.UTF8, utf8Name
+	}
+
+	if looksCyrillic(sample) {
+		return charmap.Windows1251, "windows-1251"
+	}
+	return charmap.Windows1252, "windows-1252"
+}
+
+// detectUTF16 checks for UTF-16 without a BOM. Mostly-ASCII UTF-16 text has a null byte in every second position
+func detectUTF16(sample []byte) (encoding.Encoding, string) {
+	if len(sample) < 2 {
+		return nil, ""
+	}
+
+	var evenNulls, oddNulls int
+	for i, b := range sample {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			evenNulls++
+		} else {
+			oddNulls++
+		}
+	}
+
+	half := len(sample) / 2
+	switch {
+	case oddNulls > half*3/10 && evenNulls < half/20:
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), "utf-16le"
+	case evenNulls > half*3/10 && oddNulls < half/20:
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM), "utf-16be"
+	}
+	return nil, ""
+}
+
+// validUTF8 checks that the sample is valid UTF-8, ignoring a rune that was cut off at the end of the sample
+func validUTF8(sample []byte) bool {
+	for i := 1; i < utf8.UTFMax && i <= len(sample); i++ {
+		if utf8.RuneStart(sample[len(sample)-i]) {
+			if !utf8.FullRune(sample[len(sample)-i:]) {
+				sample = sample[:len(sample)-i]
+			}
+			break
+		}
+	}
+	return utf8.Valid(sample)
+}
+
+// looksCyrillic guesses whether a single byte encoded sample is Windows-1251 rather than Windows-1252.
+// Cyrillic words consist entirely of high bytes, whereas accented Latin letters are usually surrounded by ASCII
+func looksCyrillic(sample []byte) bool {
+	var high, adjacent int
+	for i, b := range sample {
+		if b < 0x80 {
+			continue
+		}
+		high++
+		if (i > 0 && sample[i-1] >= 0x80) || (i+1 < len(sample) && sample[i+1] >= 0x80) {
+			adjacent++
+		}
+	}
+	return high > 0 && adjacent*2 > high
+}
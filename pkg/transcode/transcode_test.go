@@ -0,0 +1,53 @@
+package transcode
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// TestNewReader tests detecting and transcoding common encodings
+func TestNewReader(t *testing.T) {
+	const text = "пароль:Müller\n"
+
+	utf16le, _ := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder().Bytes([]byte(text))
+	utf16beNoBOM, _ := unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewEncoder().Bytes([]byte("user@example.com:pass\n"))
+	cp1251, _ := charmap.Windows1251.NewEncoder().Bytes([]byte("пароль:секрет\n"))
+	latin1, _ := charmap.Windows1252.NewEncoder().Bytes([]byte("user:Müller\n"))
+
+	tests := []struct {
+		input    []byte
+		name     string
+		wantName string
+		want     string
+	}{
+		{[]byte(text), Auto, "utf-8", text},
+		{append([]byte{0xef, 0xbb, 0xbf}, text...), Auto, "utf-8 (BOM)", text},
+		{utf16le, Auto, "utf-16le", text},
+		{utf16beNoBOM, Auto, "utf-16be", "user@example.com:pass\n"},
+		{cp1251, Auto, "windows-1251", "пароль:секрет\n"},
+		{latin1, Auto, "windows-1252", "user:Müller\n"},
+		{cp1251, "cp1251", "windows-1251", "пароль:секрет\n"},
+	}
+
+	for _, tt := range tests {
+		r, name, err := NewReader(bytes.NewReader(tt.input), tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if name != tt.wantName {
+			t.Errorf("Expected encoding %s, found %s", tt.wantName, name)
+		}
+		if string(b) != tt.want {
+			t.Errorf("Expected %q, found %q", tt.want, b)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package walkfiles
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/darkmattermatt/dumpdb/pkg/globmatch"
+)
+
+// Walker recursively walks directories in lexical order. Regular files and named pipes are walked, other special files
+// are skipped
+type Walker struct {
+	// Include limits the files that are walked to those matching one of the globs. Empty means include everything
+	Include []string
+	// Exclude skips files and directories that match one of the globs
+	Exclude []string
+	// FollowSymlinks follows symbolic links to files and directories instead of skipping them
+	FollowSymlinks bool
+	// SkipCallback is called with every path that is not walked and the reason why
+	SkipCallback func(path, reason string)
+
+	visited map[string]bool
+}
+
+// Walk calls `callback` for each file below `root`. If `root` is a file then `callback` is called for it directly.
+// Glob patterns are matched against the slash-separated path relative to `root`.
+func (w *Walker) Walk(root string, callback func(path string) error) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return callback(root)
+	}
+
+	w.visited = make(map[string]bool)
+	return w.walkDir(root, root, callback)
+}
+
+func (w *Walker) walkDir(root, dir string, callback func(path string) error) error {
+	// remember the real path to avoid symlink loops
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if w.visited[real] {
+		w.skip(dir, "symlink loop")
+		return nil
+	}
+	w.visited[real] = true
+
+	// ReadDir returns entries sorted by name
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if globmatch.MatchAny(w.Exclude, rel) {
+			w.skip(path, "excluded")
+			continue
+		}
+
+		if entry.Mode()&os.ModeSymlink != 0 {
+			if !w.FollowSymlinks {
+				w.skip(path, "symlink")
+				continue
+			}
+			entry, err = os.Stat(path)
+			if err != nil {
+				w.skip(path, "broken symlink")
+				continue
+			}
+		}
+
+		if entry.IsDir() {
+			err = w.walkDir(root, path, callback)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// named pipes are read like files, as when they are passed to Walk directly
+		if !entry.Mode().IsRegular() && entry.Mode()&os.ModeNamedPipe == 0 {
+			w.skip(path, "not a regular file")
+			continue
+		}
+
+		if len(w.Include) > 0 && !globmatch.MatchAny(w.Include, rel) {
+			w.skip(path, "not included")
+			continue
+		}
+
+		err = callback(path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Walker) skip(path, reason string) {
+	if w.SkipCallback != nil {
+		w.SkipCallback(path, reason)
+	}
+}